@@ -1,91 +1,364 @@
 package main
 
 import (
+	"context"
 	"donkey/pkg/config"
 	"donkey/pkg/donkey"
+	"donkey/pkg/report"
 	"donkey/pkg/version"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	zlog "github.com/zhangyu0310/zlogger"
 )
 
+// command is a subcommand of donkey.
+type command struct {
+	name    string
+	summary string
+	options []string
+	// extra registers flags which aren't config keys.
+	extra func(set *flag.FlagSet)
+	// connect is true if command connects to testing database.
+	connect bool
+	// run runs phases of command with runner. Nil means command has its own main.
+	run func(ctx, insertCtx context.Context, cfg *config.Config, runner *donkey.Runner) error
+	// main runs command without runner, it's used if run is nil.
+	main func(cfg *config.Config) error
+}
+
+// Flags which aren't config keys.
 var (
-	dbType         = flag.String("db-type", "mysql", "Type of testing Database")
-	host           = flag.String("host", "127.0.0.1", "Host of testing Database")
-	port           = flag.Int("port", 3306, "Port of testing Database")
-	user           = flag.String("user", "root", "User of testing Database")
-	pass           = flag.String("password", "", "Password of testing Database")
-	database       = flag.String("db", "my_donkey", "Database of testing Database")
-	insertRows     = flag.Uint64("rows", 0, "Number of insert rows. (0 is infinity)")
-	frontSQL       = flag.String("front-SQL", "", "SQL file of forward SQL. Running before testing")
-	postSQL        = flag.String("post-SQL", "", "SQL file of post SQL. Running after testing")
-	uniqueSyntax   = flag.String("unique-syntax", "", "Unique syntax for create table")
-	routineNum     = flag.Uint("routine-num", 0, "Number of testing routine. (0/1 both single routine)")
-	insertData     = flag.Bool("insert-data", true, "Insert test data to testing Database")
-	checkData      = flag.Bool("check-data", true, "Check test data from testing Database")
-	insertPackage  = flag.Uint("insert-package", 0, "Number of rows in once insert. (0/1 both single row)")
-	extraColumnNum = flag.Uint("extra-column-num", 0, "Testing table extra column number")
-	insertDelay    = flag.Int64("insert-delay", 0, "Insert delay. (ms)")
-	timeConsume    = flag.Bool("time-consume", false, "Print time consume. (s)")
+	// dropDatabase is a flag of cleanup.
+	dropDatabase bool
+	// inspectFormat, inspectIds and inspectOutput are flags of inspect.
+	inspectFormat string
+	inspectIds    string
+	inspectOutput string
 )
 
-func cmdConfigSetToGlobal(cfg *config.Config) {
-	cfg.DbType = *dbType
-	cfg.Host = *host
-	cfg.Port = *port
-	cfg.User = *user
-	cfg.Pass = *pass
-	cfg.Database = *database
-	cfg.InsertRows = *insertRows
-	cfg.FrontSQL = *frontSQL
-	cfg.PostSQL = *postSQL
-	cfg.UniqueSyntax = *uniqueSyntax
-	if *routineNum == 0 {
-		cfg.RoutineNum = 1
-	} else {
-		cfg.RoutineNum = *routineNum
+func join(groups ...[]string) []string {
+	var names []string
+	for _, group := range groups {
+		names = append(names, group...)
 	}
-	cfg.InsertData = *insertData
-	cfg.CheckData = *checkData
-	if *insertPackage == 0 {
-		cfg.InsertPackage = 1
-	} else {
-		cfg.InsertPackage = *insertPackage
+	return names
+}
+
+var commands = []*command{
+	{
+		name:    "prepare",
+		summary: "Run front SQL, create testing database and table",
+		options: join(connectOptions, []string{"workload", "front-SQL", "unique-syntax", "extra-column-num", "work-dir"}),
+		connect: true,
+		run: func(ctx, _ context.Context, _ *config.Config, runner *donkey.Runner) error {
+			return runner.Prepare(ctx)
+		},
+	},
+	{
+		name:    "insert",
+		summary: "Insert testing data, or transfer between accounts in bank workload",
+		options: join(connectOptions, []string{"workload", "rows", "insert-package", "insert-method", "isolation",
+			"tx-statements", "update-percent", "delete-percent", "accounts", "seed", "archive-data",
+			"extra-column-num", "insert-delay", "rate", "duration", "schedule", "work-dir"}, reportOptions),
+		connect: true,
+		run:     insert,
+	},
+	{
+		name:    "check",
+		summary: "Check testing data with archives, then run post SQL",
+		options: join(connectOptions, []string{"workload", "check-batch", "reverse-check", "seed", "post-SQL",
+			"extra-column-num", "work-dir"}, reportOptions),
+		connect: true,
+		run:     check,
+	},
+	{
+		name:    "run",
+		summary: "Run all phases: prepare, insert, check and post SQL",
+		options: allOptions(),
+		connect: true,
+		run:     run,
+	},
+	{
+		name:    "inspect",
+		summary: "Print manifest and archives in work dir, validate, dump or look up archived entries",
+		options: []string{"extra-column-num", "work-dir"},
+		extra: func(set *flag.FlagSet) {
+			set.StringVar(&inspectFormat, "format", "", "Dump all entries as json (a JSON object per line) or csv. (empty is only stat)")
+			set.StringVar(&inspectIds, "id", "", "Look up entries of ids in all archives, ids are separated by comma")
+			set.StringVar(&inspectOutput, "output", "", "File of dumped entries. (empty is stdout)")
+		},
+		main: inspect,
+	},
+	{
+		name:    "cleanup",
+		summary: "Drop testing table (or database), and remove archives and manifest in work dir",
+		options: join(connectOptions, []string{"work-dir"}),
+		extra: func(set *flag.FlagSet) {
+			set.BoolVar(&dropDatabase, "drop-db", false, "Drop the whole testing database instead of tables")
+		},
+		connect: true,
+		main: func(cfg *config.Config) error {
+			return donkey.Cleanup(cfg, dropDatabase)
+		},
+	},
+}
+
+func allOptions() []string {
+	names := make([]string, 0, len(options))
+	for _, o := range options {
+		names = append(names, o.name)
+	}
+	return names
+}
+
+func usage() {
+	fmt.Println("Usage: donkey <command> [flags]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.summary)
 	}
-	cfg.ExtraColumnNum = *extraColumnNum
-	cfg.InsertDelay = *insertDelay
-	cfg.TimeConsume = *timeConsume
+	fmt.Printf("  %-8s %s\n", "version", "Print version")
+	fmt.Println()
+	fmt.Println(`Run "donkey <command> -h" for flags of command. Without command, donkey runs all phases.`)
 }
 
 func main() {
-	h := flag.Bool("h", false, "show usage")
-	help := flag.Bool("help", false, "show usage")
-	v := flag.Bool("v", false, "show version")
-	ver := flag.Bool("version", false, "show version")
-	flag.Parse()
-	if *h || *help {
-		flag.Usage()
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	} else if len(args) > 0 {
+		switch args[0] {
+		case "-h", "-help", "--help":
+			usage()
+			os.Exit(0)
+		case "-v", "-version", "--version":
+			name = "version"
+		}
+	}
+	if name == "help" {
+		usage()
 		os.Exit(0)
 	}
-	if *v || *ver {
+	if name == "version" {
 		fmt.Println(version.VerInfo())
 		os.Exit(0)
 	}
-	if *pass == "" {
-		fmt.Println("Database password must be input.")
+	var cmd *command
+	for _, c := range commands {
+		if c.name == name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Printf("Unknown command %q\n\n", name)
+		usage()
 		os.Exit(1)
 	}
-	config.InitializeConfig(cmdConfigSetToGlobal)
+	os.Exit(cmd.execute(args))
+}
 
-	err := donkey.Initialize()
+// execute parses flags of command, runs it and returns exit code.
+// Exit code is 2 if verification fails, and 1 for other errors.
+func (cmd *command) execute(args []string) int {
+	set, load := flagSet("donkey "+cmd.name, cmd.options)
+	if cmd.extra != nil {
+		cmd.extra(set)
+	}
+	set.Usage = func() {
+		_, _ = fmt.Fprintf(set.Output(), "Usage: donkey %s [flags]\n\n%s.\n\nFlags:\n", cmd.name, cmd.summary)
+		set.PrintDefaults()
+	}
+	_ = set.Parse(args)
+	if set.NArg() != 0 {
+		fmt.Printf("Unexpected arguments %v\n", set.Args())
+		set.Usage()
+		return 1
+	}
+	cfg, err := load()
+	if err != nil {
+		fmt.Println("Init config failed, err:", err)
+		return 1
+	}
+	// SQLite is a local file, it doesn't have password.
+	if cmd.connect && cfg.Pass == "" && strings.ToLower(cfg.DbType) != "sqlite" {
+		fmt.Println("Database password must be input.")
+		return 1
+	}
+	if cmd.run == nil {
+		err = cmd.main(cfg)
+		if err != nil {
+			fmt.Printf("Donkey %s failed, err: %s\n", cmd.name, err)
+			return 1
+		}
+		return 0
+	}
+
+	err = zlog.New(cfg.WorkDir, "donkey_result", false, zlog.LogLevelAll)
+	if err != nil {
+		fmt.Println("Logger init failed. err:", err)
+		return 1
+	}
+	// The first signal stops inserting, and the second one stops everything.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	insertCtx, stopInsert := context.WithCancel(ctx)
+	defer stopInsert()
+	esc := make(chan os.Signal, 1)
+	signal.Notify(esc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-esc
+		fmt.Println(sig)
+		stopInsert()
+		sig = <-esc
+		fmt.Println(sig)
+		cancel()
+	}()
+
+	runner, err := donkey.NewRunner(cfg)
 	if err != nil {
 		fmt.Println("Init donkey failed, err:", err)
-		os.Exit(1)
+		return 1
 	}
-	defer donkey.Close()
-	err = donkey.Run()
+	err = runner.ServeMetrics()
 	if err != nil {
-		fmt.Println("Run donkey failed, err:", err)
-		os.Exit(1)
+		runner.Close()
+		fmt.Println("Serve metrics failed, err:", err)
+		return 1
+	}
+	err = cmd.run(ctx, insertCtx, cfg, runner)
+	writeReports(cfg, runner.Report(err))
+	runner.Close()
+	if err != nil {
+		fmt.Printf("Donkey %s failed, err: %s\n", cmd.name, err)
+		if verifyFailed(err) {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// verifyFailed reports whether err is a failure of verification, instead of an error of running.
+func verifyFailed(err error) bool {
+	return errors.Is(err, donkey.ErrCheckFailed) || errors.Is(err, donkey.ErrBalanceViolated)
+}
+
+// writeReports writes report to files of config, failing to write is only printed.
+func writeReports(cfg *config.Config, rep *report.Report) {
+	if cfg.Report != "" {
+		if err := rep.WriteJSON(cfg.Report); err != nil {
+			fmt.Println("Write report failed, err:", err)
+		}
+	}
+	if cfg.JUnitReport != "" {
+		if err := rep.WriteJUnit(cfg.JUnitReport); err != nil {
+			fmt.Println("Write JUnit report failed, err:", err)
+		}
+	}
+}
+
+// inspect prints archives in work dir, entries are written to output file if it's set.
+func inspect(cfg *config.Config) error {
+	opts := donkey.InspectOptions{Format: inspectFormat}
+	for _, field := range strings.Split(inspectIds, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			fmt.Printf("Id %q is not a number\n", field)
+			return err
+		}
+		opts.Ids = append(opts.Ids, id)
+	}
+	w := os.Stdout
+	if inspectOutput != "" {
+		f, err := os.Create(inspectOutput)
+		if err != nil {
+			fmt.Println("Create output file failed, err:", err)
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		w = f
+	}
+	return donkey.Inspect(cfg, w, opts)
+}
+
+// insert runs insert phase of workload, bank workload checks total balance while transferring.
+func insert(_, insertCtx context.Context, cfg *config.Config, runner *donkey.Runner) error {
+	if cfg.Workload == config.WorkloadBank {
+		return runner.Bank(insertCtx)
+	}
+	return runner.Insert(insertCtx)
+}
+
+// check runs check phase and post SQL. Failed verification is returned after post SQL.
+func check(ctx, _ context.Context, cfg *config.Config, runner *donkey.Runner) error {
+	var verifyErr error
+	if cfg.Workload == config.WorkloadBank {
+		fmt.Println("Bank workload is checked while transferring, there is nothing to check.")
+	} else {
+		verifyErr = runner.Check(ctx)
+		if verifyErr != nil && !verifyFailed(verifyErr) {
+			return verifyErr
+		}
+	}
+	err := runner.Finish(ctx)
+	if err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+func run(ctx, insertCtx context.Context, cfg *config.Config, runner *donkey.Runner) error {
+	err := runner.Prepare(ctx)
+	if err != nil {
+		return err
+	}
+	begin := time.Now()
+	// Failed verification is returned after post SQL.
+	var verifyErr error
+	if cfg.Workload == config.WorkloadBank {
+		err = runner.Bank(insertCtx)
+		if err != nil && !verifyFailed(err) {
+			return err
+		}
+		verifyErr = err
+	}
+	if cfg.InsertData && cfg.Workload == config.WorkloadInsert {
+		err = runner.Insert(insertCtx)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.CheckData && cfg.Workload == config.WorkloadInsert {
+		err = runner.Check(ctx)
+		if err != nil && !verifyFailed(err) {
+			return err
+		}
+		verifyErr = err
+	}
+	end := time.Now()
+	if cfg.TimeConsume {
+		sub := end.Sub(begin).Seconds()
+		fmt.Printf("Time consume is %fs\n", sub)
+		zlog.InfoF("Time consume is %fs", sub)
+	}
+	err = runner.Finish(ctx)
+	if err != nil {
+		return err
 	}
+	return verifyErr
 }
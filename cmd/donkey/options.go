@@ -0,0 +1,214 @@
+package main
+
+import (
+	"donkey/pkg/config"
+	"donkey/pkg/operator"
+	"flag"
+	"strings"
+)
+
+// option is a command line flag of a config key.
+type option struct {
+	name string
+	// bind registers flag in set, value of flag is stored in flags.
+	bind func(set *flag.FlagSet, flags *config.Config)
+	// apply copies value of flag from flags to cfg.
+	apply func(cfg, flags *config.Config)
+}
+
+func stringOption(name, usage string, field func(*config.Config) *string) *option {
+	return &option{
+		name: name,
+		bind: func(set *flag.FlagSet, flags *config.Config) {
+			set.StringVar(field(flags), name, *field(flags), usage)
+		},
+		apply: func(cfg, flags *config.Config) { *field(cfg) = *field(flags) },
+	}
+}
+
+func boolOption(name, usage string, field func(*config.Config) *bool) *option {
+	return &option{
+		name: name,
+		bind: func(set *flag.FlagSet, flags *config.Config) {
+			set.BoolVar(field(flags), name, *field(flags), usage)
+		},
+		apply: func(cfg, flags *config.Config) { *field(cfg) = *field(flags) },
+	}
+}
+
+func intOption(name, usage string, field func(*config.Config) *int) *option {
+	return &option{
+		name: name,
+		bind: func(set *flag.FlagSet, flags *config.Config) {
+			set.IntVar(field(flags), name, *field(flags), usage)
+		},
+		apply: func(cfg, flags *config.Config) { *field(cfg) = *field(flags) },
+	}
+}
+
+func int64Option(name, usage string, field func(*config.Config) *int64) *option {
+	return &option{
+		name: name,
+		bind: func(set *flag.FlagSet, flags *config.Config) {
+			set.Int64Var(field(flags), name, *field(flags), usage)
+		},
+		apply: func(cfg, flags *config.Config) { *field(cfg) = *field(flags) },
+	}
+}
+
+func uintOption(name, usage string, field func(*config.Config) *uint) *option {
+	return &option{
+		name: name,
+		bind: func(set *flag.FlagSet, flags *config.Config) {
+			set.UintVar(field(flags), name, *field(flags), usage)
+		},
+		apply: func(cfg, flags *config.Config) { *field(cfg) = *field(flags) },
+	}
+}
+
+func uint64Option(name, usage string, field func(*config.Config) *uint64) *option {
+	return &option{
+		name: name,
+		bind: func(set *flag.FlagSet, flags *config.Config) {
+			set.Uint64Var(field(flags), name, *field(flags), usage)
+		},
+		apply: func(cfg, flags *config.Config) { *field(cfg) = *field(flags) },
+	}
+}
+
+func durationOption(name, usage string, field func(*config.Config) *config.Duration) *option {
+	return &option{
+		name: name,
+		bind: func(set *flag.FlagSet, flags *config.Config) {
+			set.Var(field(flags), name, usage)
+		},
+		apply: func(cfg, flags *config.Config) { *field(cfg) = *field(flags) },
+	}
+}
+
+func scheduleOption(name, usage string, field func(*config.Config) *config.Schedule) *option {
+	return &option{
+		name: name,
+		bind: func(set *flag.FlagSet, flags *config.Config) {
+			set.Var(field(flags), name, usage)
+		},
+		apply: func(cfg, flags *config.Config) { *field(cfg) = *field(flags) },
+	}
+}
+
+// options are flags of all config keys, commands choose theirs by name.
+var options = []*option{
+	stringOption("workload", "Workload of testing (insert/bank)",
+		func(c *config.Config) *string { return &c.Workload }),
+	stringOption("db-type", "Type of testing Database ("+strings.Join(operator.Names(), "/")+")",
+		func(c *config.Config) *string { return &c.DbType }),
+	stringOption("host", "Host of testing Database",
+		func(c *config.Config) *string { return &c.Host }),
+	intOption("port", "Port of testing Database",
+		func(c *config.Config) *int { return &c.Port }),
+	stringOption("user", "User of testing Database",
+		func(c *config.Config) *string { return &c.User }),
+	stringOption("password", "Password of testing Database",
+		func(c *config.Config) *string { return &c.Pass }),
+	stringOption("db", "Database of testing Database",
+		func(c *config.Config) *string { return &c.Database }),
+	uint64Option("rows", "Number of insert rows. (0 is infinity)",
+		func(c *config.Config) *uint64 { return &c.InsertRows }),
+	stringOption("front-SQL", "SQL file of forward SQL. Running before testing",
+		func(c *config.Config) *string { return &c.FrontSQL }),
+	stringOption("post-SQL", "SQL file of post SQL. Running after testing",
+		func(c *config.Config) *string { return &c.PostSQL }),
+	stringOption("unique-syntax", "Unique syntax for create table",
+		func(c *config.Config) *string { return &c.UniqueSyntax }),
+	uintOption("routine-num", "Number of testing routine. (0/1 both single routine)",
+		func(c *config.Config) *uint { return &c.RoutineNum }),
+	boolOption("insert-data", "Insert test data to testing Database",
+		func(c *config.Config) *bool { return &c.InsertData }),
+	boolOption("check-data", "Check test data from testing Database",
+		func(c *config.Config) *bool { return &c.CheckData }),
+	uintOption("check-batch", "Number of rows in once check query. (0/1 both single row)",
+		func(c *config.Config) *uint { return &c.CheckBatch }),
+	boolOption("reverse-check", "Check every row of testing table is in archives",
+		func(c *config.Config) *bool { return &c.ReverseCheck }),
+	uintOption("insert-package", "Number of rows in once insert. (0/1 both single row)",
+		func(c *config.Config) *uint { return &c.InsertPackage }),
+	stringOption("insert-method", "Method of insert statement (text/prepared/bulk)",
+		func(c *config.Config) *string { return &c.InsertMethod }),
+	stringOption("isolation", "Isolation level of insert transaction (read-uncommitted/read-committed/repeatable-read/serializable)",
+		func(c *config.Config) *string { return &c.Isolation }),
+	uintOption("tx-statements", "Number of insert statements in a transaction. (0 is autocommit)",
+		func(c *config.Config) *uint { return &c.TxStatements }),
+	uintOption("update-percent", "Percent of operations updating an inserted row",
+		func(c *config.Config) *uint { return &c.UpdatePercent }),
+	uintOption("delete-percent", "Percent of operations deleting an inserted row",
+		func(c *config.Config) *uint { return &c.DeletePercent }),
+	uintOption("accounts", "Number of accounts in bank workload",
+		func(c *config.Config) *uint { return &c.Accounts }),
+	stringOption("seed", "Seed of testing data, values are derived from seed and row id",
+		func(c *config.Config) *string { return &c.Seed }),
+	boolOption("archive-data", "Archive inserted rows. (rows without archive are checked with seed)",
+		func(c *config.Config) *bool { return &c.ArchiveData }),
+	uintOption("extra-column-num", "Testing table extra column number",
+		func(c *config.Config) *uint { return &c.ExtraColumnNum }),
+	int64Option("insert-delay", "Insert delay. (ms)",
+		func(c *config.Config) *int64 { return &c.InsertDelay }),
+	uint64Option("rate", "Target rate of rows per second of all routines, latency is measured from intended start. (0 is off)",
+		func(c *config.Config) *uint64 { return &c.Rate }),
+	durationOption("duration", "Max `duration` of inserting, e.g. 6h. (0 is unlimited)",
+		func(c *config.Config) *config.Duration { return &c.Duration }),
+	scheduleOption("schedule", "Insert `stages` changing routine-num, insert-delay, insert-package and rate, e.g. \"10m:routine-num=4,insert-delay=100;2h\"",
+		func(c *config.Config) *config.Schedule { return &c.Schedule }),
+	boolOption("time-consume", "Print time consume. (s)",
+		func(c *config.Config) *bool { return &c.TimeConsume }),
+	uintOption("report-interval", "Interval of printing throughput and latency. (s, 0 is only summary)",
+		func(c *config.Config) *uint { return &c.ReportInterval }),
+	stringOption("metrics-addr", "Address serving Prometheus metrics on /metrics, e.g. :9100. (empty is off)",
+		func(c *config.Config) *string { return &c.MetricsAddr }),
+	stringOption("report", "File of JSON report of the run. (empty is off)",
+		func(c *config.Config) *string { return &c.Report }),
+	stringOption("junit-report", "File of JUnit XML report of the run. (empty is off)",
+		func(c *config.Config) *string { return &c.JUnitReport }),
+	stringOption("work-dir", "Dir of archives and result logs",
+		func(c *config.Config) *string { return &c.WorkDir }),
+}
+
+// Names of options shared by commands.
+var (
+	connectOptions = []string{"db-type", "host", "port", "user", "password", "db", "routine-num"}
+	reportOptions  = []string{"report-interval", "metrics-addr", "report", "junit-report"}
+)
+
+// flagSet returns flag set of command with options of names, in order of options.
+// Config file is always a flag, flags set in command line cover it.
+// The returned function loads config of config file and flags, it must be called after parsing.
+func flagSet(name string, names []string) (*flag.FlagSet, func() (*config.Config, error)) {
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	configFile := set.String("config", "", "Config file (yaml/toml/json). Command flags cover config file")
+	chosen := make(map[string]bool, len(names))
+	for _, n := range names {
+		chosen[n] = true
+	}
+	flags := config.DefaultConfig()
+	byName := make(map[string]*option, len(names))
+	for _, o := range options {
+		if chosen[o.name] {
+			o.bind(set, flags)
+			byName[o.name] = o
+		}
+	}
+	load := func() (*config.Config, error) {
+		err := config.InitializeConfig(*configFile, func(cfg *config.Config) {
+			// Only flags set in command line cover config file.
+			set.Visit(func(f *flag.Flag) {
+				if o, ok := byName[f.Name]; ok {
+					o.apply(cfg, flags)
+				}
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		return config.GetGlobalConfig(), nil
+	}
+	return set, load
+}
@@ -4,29 +4,113 @@ import (
 	"donkey/pkg/archive/codec"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 )
 
 var (
-	ErrArchiveIncomplete = errors.New("archive file is incomplete")
-	ErrReadEndOfFile     = errors.New("read end of archive file")
+	ErrArchiveIncomplete       = errors.New("archive file is incomplete")
+	ErrReadEndOfFile           = errors.New("read end of archive file")
+	ErrArchiveCorrupted        = errors.New("archive file is corrupted")
+	ErrDifferentExtraColumnNum = errors.New("extra column number is different from archive")
+	ErrMutationNotSupported    = errors.New("archive version doesn't support update or delete")
 )
 
+const (
+	// FilePrefix is the prefix of archive file name, routine id follows it.
+	FilePrefix = "donkey_archive_"
+	// IndeterminateFilePrefix is the prefix of indeterminate archive file name, routine id follows it.
+	IndeterminateFilePrefix = "donkey_indeterminate_"
+)
+
+// maxRecordSize is the max payload length of a record, bigger length must be corrupted.
+const maxRecordSize = 1 << 20
+
+// CorruptedError is a corrupted header or record of archive file.
+// It's a fault of client side (e.g. a flipped bit of disk), not of testing database.
+type CorruptedError struct {
+	FileName string
+	Offset   int64
+	Reason   string
+}
+
+func (e *CorruptedError) Error() string {
+	return fmt.Sprintf("archive %s is corrupted at offset %d: %s", e.FileName, e.Offset, e.Reason)
+}
+
+func (e *CorruptedError) Is(target error) bool {
+	return target == ErrArchiveCorrupted
+}
+
+// Op is the operation of an entry.
+type Op byte
+
+const (
+	OpInsert Op = iota
+	OpUpdate
+	OpDelete
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpInsert:
+		return "insert"
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	default:
+		return "op(" + strconv.Itoa(int(op)) + ")"
+	}
+}
+
+// Entry is an operation on a row. Insert and update entries have values of all columns,
+// delete entry has no value. Archive of old versions only has insert entries.
 type Entry struct {
-	Id        uint64
+	Id uint64
+	Op Op
+	// Version of row, it's 0 when inserted and increases by every update or delete.
+	Version   uint64
 	Uuid      string
 	ExtraUuid []string
 }
 
+// Options of archive. They are written to header of new archive file,
+// and are checked with header of existing one.
+type Options struct {
+	ExtraColumnNum uint
+	RunId          string
+	// Checkpoint is a known state of existing archive file, e.g. recorded in manifest.
+	// Records before it are trusted when opening, only the tail after it is validated.
+	Checkpoint Checkpoint
+}
+
+// Checkpoint is the number of entries before offset of archive file, 0 offset is unknown.
+type Checkpoint struct {
+	Entries uint64
+	Offset  int64
+}
+
 type Archive struct {
-	Id          int
-	archive     *os.File
+	Id       int
+	fileName string
+	archive  *os.File
+	header   *Header
+	// dataOffset is the offset of the first record
+	dataOffset  int64
 	readOffset  int64
 	writeOffset int64
 	buffer      []byte
 	EntityNum   uint64
+	// entryCount is the number of readable entries in file
+	entryCount uint64
+	// droppedBytes is the size of torn tail truncated when opening
+	droppedBytes int64
+	// extraNum is the extra column number of entries.
+	extraNum uint
 }
 
 func (entry *Entry) Encode() []byte {
@@ -41,9 +125,146 @@ func (entry *Entry) Encode() []byte {
 	return data
 }
 
+// encodePayload encodes entry in the format of archive version.
+// Before MutationVersion, payload is the same as Encode.
+func (entry *Entry) encodePayload(version uint64) []byte {
+	if version < MutationVersion {
+		return entry.Encode()
+	}
+	data := make([]byte, 0, 48*(len(entry.ExtraUuid)+1))
+	data = append(data, codec.EncodeVarUint64(entry.Id)...)
+	data = append(data, byte(entry.Op))
+	data = append(data, codec.EncodeVarUint64(entry.Version)...)
+	if entry.Op == OpDelete {
+		return data
+	}
+	data = append(data, codec.EncodeVarUint64(uint64(len(entry.Uuid)))...)
+	data = append(data, []byte(entry.Uuid)...)
+	for i := 0; i < len(entry.ExtraUuid); i++ {
+		data = append(data, codec.EncodeVarUint64(uint64(len(entry.ExtraUuid[i])))...)
+		data = append(data, []byte(entry.ExtraUuid[i])...)
+	}
+	return data
+}
+
+// encodeRecord encodes entry to a record with checksum.
+func (entry *Entry) encodeRecord(version uint64) []byte {
+	payload := entry.encodePayload(version)
+	data := make([]byte, 0, len(payload)+8)
+	data = append(data, codec.EncodeVarUint64(uint64(len(payload)))...)
+	data = append(data, payload...)
+	crc := codec.EncodeFixedUint32(crc32.Checksum(payload, crc32cTable))
+	data = append(data, crc[:]...)
+	return data
+}
+
+// decodeEntry decodes payload of record, payload must be exactly one entry.
+func decodeEntry(payload []byte, extraNum uint, version uint64) (*Entry, error) {
+	idVarInt, index, err := codec.GetVarUint64(payload, 0)
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{
+		Id:        codec.DecodeVarUint64(idVarInt),
+		ExtraUuid: make([]string, 0, extraNum),
+	}
+	if version >= MutationVersion {
+		if index == len(payload) {
+			return nil, errors.New("record has no operation")
+		}
+		entry.Op = Op(payload[index])
+		if entry.Op > OpDelete {
+			return nil, fmt.Errorf("unknown operation %d", entry.Op)
+		}
+		var versionVarInt codec.VarUint64
+		versionVarInt, index, err = codec.GetVarUint64(payload, index+1)
+		if err != nil {
+			return nil, err
+		}
+		entry.Version = codec.DecodeVarUint64(versionVarInt)
+		if entry.Op == OpDelete {
+			extraNum = 0
+			entry.ExtraUuid = nil
+		}
+	}
+	for i := uint(0); i < extraNum+1 && entry.Op != OpDelete; i++ {
+		var dataLenVarInt codec.VarUint64
+		dataLenVarInt, index, err = codec.GetVarUint64(payload, index)
+		if err != nil {
+			return nil, err
+		}
+		dataEnd := uint64(index) + codec.DecodeVarUint64(dataLenVarInt)
+		if dataEnd > uint64(len(payload)) {
+			return nil, errors.New("uuid is out of record")
+		}
+		if i == 0 {
+			entry.Uuid = string(payload[index:dataEnd])
+		} else {
+			entry.ExtraUuid = append(entry.ExtraUuid, string(payload[index:dataEnd]))
+		}
+		index = int(dataEnd)
+	}
+	if index != len(payload) {
+		return nil, errors.New("record has more data than entry")
+	}
+	return entry, nil
+}
+
 func NewArchive(routineId int) (*Archive, error) {
-	idStr := strconv.Itoa(routineId)
-	fileName := "donkey_archive_" + idStr
+	return NewArchiveInDir(".", routineId, Options{})
+}
+
+// NewArchiveInDir opens (or creates) archive file of routine in dir.
+func NewArchiveInDir(dir string, routineId int, opts Options) (*Archive, error) {
+	return openArchive(filepath.Join(dir, FilePrefix+strconv.Itoa(routineId)), routineId, opts)
+}
+
+// NewIndeterminateArchiveInDir opens (or creates) indeterminate archive file of routine in dir.
+// It records entries which may be written or not, e.g. connection is broken when committing.
+func NewIndeterminateArchiveInDir(dir string, routineId int, opts Options) (*Archive, error) {
+	return openArchive(filepath.Join(dir, IndeterminateFilePrefix+strconv.Itoa(routineId)), routineId, opts)
+}
+
+// OpenReadOnly opens an existing archive file for reading, the file is never changed.
+// Extra column number is read from header, extraNum is only used for legacy archive.
+// Records aren't validated or counted when opening, GetOneEntry returns ErrArchiveIncomplete at a torn tail.
+// Torn header is counted in DroppedBytes, and there is no entry.
+func OpenReadOnly(fileName string, extraNum uint) (*Archive, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	archive := &Archive{
+		fileName:    fileName,
+		archive:     f,
+		writeOffset: stat.Size(),
+		buffer:      make([]byte, 0, 10240),
+	}
+	archive.header, archive.dataOffset, err = readHeader(f, fileName, stat.Size())
+	if errors.Is(err, errTornHeader) {
+		archive.header = &Header{Version: CurrentVersion}
+		archive.droppedBytes = stat.Size()
+		archive.writeOffset = 0
+		err = nil
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if archive.header.Version != LegacyVersion {
+		extraNum = uint(archive.header.ExtraColumnNum)
+	}
+	archive.extraNum = extraNum
+	archive.SeekForRead()
+	return archive, nil
+}
+
+func openArchive(fileName string, routineId int, opts Options) (*Archive, error) {
 	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		fmt.Printf("Open archive %s failed, err: %s\n", fileName, err)
@@ -52,19 +273,148 @@ func NewArchive(routineId int) (*Archive, error) {
 	stat, err := f.Stat()
 	if err != nil {
 		fmt.Printf("Get archive file %s stat failed, err: %s\n", fileName, err)
+		_ = f.Close()
 		return nil, err
 	}
 	archive := &Archive{
 		Id:          routineId,
+		fileName:    fileName,
 		archive:     f,
-		readOffset:  0,
 		writeOffset: stat.Size(),
 		buffer:      make([]byte, 0, 10240),
 		EntityNum:   0,
+		extraNum:    opts.ExtraColumnNum,
+	}
+	if stat.Size() != 0 {
+		archive.header, archive.dataOffset, err = readHeader(f, fileName, stat.Size())
+		if errors.Is(err, errTornHeader) {
+			// Killed when creating the file, there is nothing but a part of header.
+			// Corrupted header isn't torn, it's returned and the file is kept.
+			fmt.Printf("Archive %s has an incomplete header, drop it (%d bytes)\n", fileName, stat.Size())
+			archive.droppedBytes = stat.Size()
+			err = archive.truncate(0)
+		}
+		if err != nil {
+			fmt.Printf("Read archive %s header failed, err: %s\n", fileName, err)
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	if archive.writeOffset == 0 {
+		// New archive file, write header first.
+		archive.header = &Header{
+			Version:        CurrentVersion,
+			ExtraColumnNum: uint64(opts.ExtraColumnNum),
+			RunId:          opts.RunId,
+		}
+		data := archive.header.Encode()
+		_, err = f.WriteAt(data, 0)
+		if err == nil {
+			err = f.Sync()
+		}
+		if err != nil {
+			fmt.Printf("Write archive %s header failed, err: %s\n", fileName, err)
+			_ = f.Close()
+			return nil, err
+		}
+		archive.dataOffset = int64(len(data))
+		archive.writeOffset = int64(len(data))
+	} else if archive.header.Version != LegacyVersion &&
+		archive.header.ExtraColumnNum != uint64(opts.ExtraColumnNum) {
+		fmt.Printf("Archive %s has %d extra columns, but config is %d\n",
+			fileName, archive.header.ExtraColumnNum, opts.ExtraColumnNum)
+		_ = f.Close()
+		return nil, ErrDifferentExtraColumnNum
+	}
+	err = archive.recoverTail(opts.ExtraColumnNum, opts.Checkpoint)
+	if err != nil {
+		fmt.Printf("Recover archive %s failed, err: %s\n", fileName, err)
+		_ = f.Close()
+		return nil, err
 	}
 	return archive, nil
 }
 
+// recoverTail validates records after checkpoint, and truncates the incomplete record at the end of file.
+// It's left by a crash (e.g. kill -9) in the middle of AppendEntries.
+// Records before checkpoint are trusted and counted by it, check validates them. Without checkpoint,
+// all records are validated. A corrupted record is never truncated, its CorruptedError is returned,
+// because entries appended after it can't be read.
+func (archive *Archive) recoverTail(extraNum uint, checkpoint Checkpoint) error {
+	archive.SeekForRead()
+	defer archive.SeekForRead()
+	// Checkpoint beyond the end of file is lost data, all records are counted to report it.
+	if checkpoint.Offset > archive.dataOffset && checkpoint.Offset <= archive.writeOffset {
+		archive.readOffset = checkpoint.Offset
+		archive.entryCount = checkpoint.Entries
+	}
+	validEnd := archive.readOffset
+	for {
+		_, err := archive.GetOneEntry(extraNum)
+		if err == nil {
+			archive.entryCount++
+			validEnd = archive.readOffset - int64(len(archive.buffer))
+			continue
+		}
+		if errors.Is(err, ErrReadEndOfFile) {
+			return nil
+		}
+		if errors.Is(err, ErrArchiveIncomplete) {
+			dropped := archive.writeOffset - validEnd
+			fmt.Printf("Archive %s ends with an incomplete record, truncate %d bytes at offset %d\n",
+				archive.fileName, dropped, validEnd)
+			archive.droppedBytes += dropped
+			return archive.truncate(validEnd)
+		}
+		return err
+	}
+}
+
+// truncate drops data of archive file after size.
+func (archive *Archive) truncate(size int64) error {
+	err := archive.archive.Truncate(size)
+	if err != nil {
+		return err
+	}
+	err = archive.archive.Sync()
+	if err != nil {
+		return err
+	}
+	archive.writeOffset = size
+	return nil
+}
+
+// Size returns the size of archive file, new entries are appended here.
+func (archive *Archive) Size() int64 {
+	return archive.writeOffset
+}
+
+// EntryCount returns the number of entries in archive file, it's 0 for read only archive.
+func (archive *Archive) EntryCount() uint64 {
+	return archive.entryCount
+}
+
+// DroppedBytes returns the number of bytes truncated when opening archive.
+// Read only archive doesn't truncate torn header, but it isn't read.
+func (archive *Archive) DroppedBytes() int64 {
+	return archive.droppedBytes
+}
+
+// ExtraColumnNum returns the extra column number of entries in archive.
+func (archive *Archive) ExtraColumnNum() uint {
+	return archive.extraNum
+}
+
+// Header returns the header of archive file. Legacy archive has an empty header.
+func (archive *Archive) Header() Header {
+	return *archive.header
+}
+
+// FileName returns the path of archive file.
+func (archive *Archive) FileName() string {
+	return archive.fileName
+}
+
 func (archive *Archive) SeekForAppend() error {
 	_, err := archive.archive.Seek(archive.writeOffset, 0)
 	if err != nil {
@@ -74,28 +424,58 @@ func (archive *Archive) SeekForAppend() error {
 	return nil
 }
 
-func (archive *Archive) AppendEntries(data []byte, entryNum uint64) error {
-	n, err := archive.archive.Write(data)
+// SeekForRead makes GetOneEntry read from the first entry.
+func (archive *Archive) SeekForRead() {
+	archive.readOffset = archive.dataOffset
+	archive.buffer = archive.buffer[:0]
+}
+
+// AppendEntries appends entries at the end of archive.
+// Entries are encoded in the format of archive version.
+func (archive *Archive) AppendEntries(entries []*Entry) error {
+	data := make([]byte, 0, len(entries)*48)
+	for _, entry := range entries {
+		if entry.Op != OpInsert && archive.header.Version < MutationVersion {
+			return ErrMutationNotSupported
+		}
+		if archive.header.Version == LegacyVersion {
+			data = append(data, entry.Encode()...)
+		} else {
+			data = append(data, entry.encodeRecord(archive.header.Version)...)
+		}
+	}
+	n, err := archive.archive.WriteAt(data, archive.writeOffset)
 	if err != nil {
 		fmt.Printf("Append entry to archive failed, err: (%s)\n", err)
 		return err
 	}
 	archive.writeOffset += int64(n)
-	archive.EntityNum += entryNum
+	archive.EntityNum += uint64(len(entries))
+	archive.entryCount += uint64(len(entries))
 	return nil
 }
 
 func (archive *Archive) AppendOneEntry(entry *Entry) error {
-	data := entry.Encode()
-	return archive.AppendEntries(data, 1)
+	return archive.AppendEntries([]*Entry{entry})
 }
 
 func (archive *Archive) Flush() {
 	_ = archive.archive.Sync()
 }
 
+func (archive *Archive) Close() {
+	_ = archive.archive.Close()
+}
+
+// readSomeData reads data before write offset into buffer, it returns io.EOF at write offset.
 func (archive *Archive) readSomeData() error {
 	data := make([]byte, 10240)
+	if remaining := archive.writeOffset - archive.readOffset; remaining < int64(len(data)) {
+		if remaining <= 0 {
+			return io.EOF
+		}
+		data = data[:remaining]
+	}
 	n, err := archive.archive.Read(data)
 	if err != nil {
 		if err != io.EOF {
@@ -174,6 +554,9 @@ func getUuidFromArchive(archive *Archive) ([]byte, error) {
 	return data, nil
 }
 
+// GetOneEntry reads the next entry of archive.
+// It returns ErrReadEndOfFile after the last entry, and
+// an error matching ErrArchiveCorrupted if the record is corrupted.
 func (archive *Archive) GetOneEntry(extraNum uint) (*Entry, error) {
 	if archive.readOffset == archive.writeOffset && len(archive.buffer) == 0 {
 		return nil, ErrReadEndOfFile
@@ -183,6 +566,45 @@ func (archive *Archive) GetOneEntry(extraNum uint) (*Entry, error) {
 		fmt.Println("Get entry seek failed, err:", err)
 		return nil, err
 	}
+	if archive.header.Version == LegacyVersion {
+		return archive.getLegacyEntry(extraNum)
+	}
+	recordOffset := archive.readOffset - int64(len(archive.buffer))
+	corrupted := func(reason string) error {
+		return &CorruptedError{FileName: archive.fileName, Offset: recordOffset, Reason: reason}
+	}
+	// Get payload length
+	payloadLenVarInt, err := archive.getVarIntFromArchive()
+	if err != nil {
+		fmt.Println("Get var int from archive failed, err:", err)
+		return nil, err
+	}
+	payloadLen := codec.DecodeVarUint64(payloadLenVarInt)
+	if payloadLen > maxRecordSize {
+		return nil, corrupted(fmt.Sprintf("record length %d is too large", payloadLen))
+	}
+	payload, err := archive.getDataFromArchive(int(payloadLen))
+	if err != nil {
+		fmt.Println("Get record from archive failed, err:", err)
+		return nil, err
+	}
+	crcData, err := archive.getDataFromArchive(4)
+	if err != nil {
+		fmt.Println("Get record checksum from archive failed, err:", err)
+		return nil, err
+	}
+	crc := codec.DecodeFixedUint32(codec.GetFixedUint32(crcData, 0))
+	if crc != crc32.Checksum(payload, crc32cTable) {
+		return nil, corrupted("record checksum mismatch")
+	}
+	entry, err := decodeEntry(payload, extraNum, archive.header.Version)
+	if err != nil {
+		return nil, corrupted(err.Error())
+	}
+	return entry, nil
+}
+
+func (archive *Archive) getLegacyEntry(extraNum uint) (*Entry, error) {
 	// Get id
 	idVarInt, err := archive.getVarIntFromArchive()
 	if err != nil {
@@ -1,8 +1,10 @@
 package archive
 
 import (
+	"errors"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/uuid"
@@ -61,3 +63,395 @@ func TestArchive_GetOneEntry(t *testing.T) {
 	}
 	_ = os.Remove("donkey_archive_2")
 }
+
+func appendTestEntries(t *testing.T, archive *Archive, num int, extraNum int) []*Entry {
+	entries := make([]*Entry, 0, num)
+	for i := 0; i < num; i++ {
+		entry := &Entry{
+			Id:   uint64(i),
+			Uuid: uuid.New().String(),
+		}
+		for j := 0; j < extraNum; j++ {
+			entry.ExtraUuid = append(entry.ExtraUuid, uuid.New().String())
+		}
+		entries = append(entries, entry)
+	}
+	err := archive.AppendEntries(entries)
+	if err != nil {
+		t.Fatal("Append entries failed, err:", err)
+	}
+	return entries
+}
+
+func TestArchive_Header(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 2, RunId: "run-1"})
+	if err != nil {
+		t.Fatal("New archive failed, err:", err)
+	}
+	entries := appendTestEntries(t, archive, 10, 2)
+	archive.Close()
+
+	archive, err = NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 2, RunId: "run-2"})
+	if err != nil {
+		t.Fatal("Reopen archive failed, err:", err)
+	}
+	defer archive.Close()
+	h := archive.Header()
+	if h.Version != CurrentVersion || h.ExtraColumnNum != 2 || h.RunId != "run-1" {
+		t.Errorf("Header is different: %+v", h)
+	}
+	for _, entry := range entries {
+		e, err := archive.GetOneEntry(2)
+		if err != nil {
+			t.Fatal("Get one entry failed, err:", err)
+		}
+		if e.Id != entry.Id || e.Uuid != entry.Uuid || e.ExtraUuid[1] != entry.ExtraUuid[1] {
+			t.Error("Entry is different")
+		}
+	}
+	if _, err = archive.GetOneEntry(2); !errors.Is(err, ErrReadEndOfFile) {
+		t.Error("Read after last entry should be end of file, err:", err)
+	}
+
+	_, err = NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 1})
+	if !errors.Is(err, ErrDifferentExtraColumnNum) {
+		t.Error("Open archive with different extra column num should fail, err:", err)
+	}
+}
+
+func TestArchive_Corrupted(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewArchiveInDir(dir, 0, Options{})
+	if err != nil {
+		t.Fatal("New archive failed, err:", err)
+	}
+	appendTestEntries(t, archive, 10, 0)
+	archive.Close()
+
+	// Flip a byte of the uuid of the 6th record.
+	fileName := filepath.Join(dir, "donkey_archive_0")
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal("Read archive file failed, err:", err)
+	}
+	headerLen := len((&Header{Version: CurrentVersion}).Encode())
+	recordLen := len((&Entry{Uuid: uuid.New().String()}).encodeRecord(CurrentVersion))
+	offset := headerLen + 5*recordLen
+	data[offset+10] ^= 0xff
+	if err = os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal("Write archive file failed, err:", err)
+	}
+
+	// Without checkpoint, all records are validated when opening.
+	var corrupted *CorruptedError
+	_, err = NewArchiveInDir(dir, 0, Options{})
+	if !errors.As(err, &corrupted) || corrupted.Offset != int64(offset) {
+		t.Errorf("Open archive should fail at corrupted offset %d, err: %v", offset, err)
+	}
+
+	// Records before checkpoint are validated by reading.
+	archive, err = NewArchiveInDir(dir, 0, Options{Checkpoint: Checkpoint{Entries: 10, Offset: int64(len(data))}})
+	if err != nil {
+		t.Fatal("Reopen archive with checkpoint failed, err:", err)
+	}
+	defer archive.Close()
+	for i := 0; i < 5; i++ {
+		if _, err = archive.GetOneEntry(0); err != nil {
+			t.Fatal("Get one entry failed, err:", err)
+		}
+	}
+	_, err = archive.GetOneEntry(0)
+	if !errors.Is(err, ErrArchiveCorrupted) {
+		t.Fatal("Corrupted record should be detected, err:", err)
+	}
+	if !errors.As(err, &corrupted) || corrupted.Offset != int64(offset) {
+		t.Errorf("Corrupted offset should be %d, err: %v", offset, err)
+	}
+
+	// The last record whose checksum mismatches is corrupted, not torn.
+	data[offset+10] ^= 0xff
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal("Write archive file failed, err:", err)
+	}
+	_, err = NewArchiveInDir(dir, 0, Options{Checkpoint: Checkpoint{Entries: 9, Offset: int64(offset + 4*recordLen)}})
+	if !errors.As(err, &corrupted) || corrupted.Offset != int64(offset+4*recordLen) {
+		t.Errorf("Open archive should fail at the last record, err: %v", err)
+	}
+	if stat, err := os.Stat(fileName); err != nil || stat.Size() != int64(len(data)) {
+		t.Error("Corrupted archive should not be truncated, err:", err)
+	}
+}
+
+func TestArchive_CorruptedHeader(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 1, RunId: uuid.New().String()})
+	if err != nil {
+		t.Fatal("New archive failed, err:", err)
+	}
+	appendTestEntries(t, archive, 10, 1)
+	headerLen := int(archive.dataOffset)
+	archive.Close()
+	fileName := filepath.Join(dir, FilePrefix+"0")
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal("Read archive file failed, err:", err)
+	}
+
+	// Every flipped bit of header is corrupted, and the file is never changed.
+	for i := 0; i < headerLen*8; i++ {
+		flipped := append([]byte{}, data...)
+		flipped[i/8] ^= 1 << (i % 8)
+		if err = os.WriteFile(fileName, flipped, 0644); err != nil {
+			t.Fatal("Write archive file failed, err:", err)
+		}
+		_, err = NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 1})
+		if !errors.Is(err, ErrArchiveCorrupted) {
+			t.Errorf("Header with bit %d flipped should be corrupted, err: %v", i, err)
+		}
+		if content, err := os.ReadFile(fileName); err != nil || string(content) != string(flipped) {
+			t.Fatalf("Archive with bit %d flipped is changed, err: %v", i, err)
+		}
+	}
+
+	// File shorter than its header is torn, it's created again.
+	if err = os.WriteFile(fileName, data[:headerLen-1], 0644); err != nil {
+		t.Fatal("Write archive file failed, err:", err)
+	}
+	archive, err = NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 1, RunId: "run-2"})
+	if err != nil {
+		t.Fatal("Open archive with torn header failed, err:", err)
+	}
+	defer archive.Close()
+	if archive.DroppedBytes() != int64(headerLen-1) || archive.Header().RunId != "run-2" || archive.EntryCount() != 0 {
+		t.Errorf("Torn header should be dropped, dropped %d bytes, header %+v",
+			archive.DroppedBytes(), archive.Header())
+	}
+}
+
+func TestArchive_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewArchiveInDir(dir, 0, Options{})
+	if err != nil {
+		t.Fatal("New archive failed, err:", err)
+	}
+	appendTestEntries(t, archive, 10, 0)
+	checkpoint := Checkpoint{Entries: archive.EntryCount(), Offset: archive.Size()}
+	appendTestEntries(t, archive, 5, 0)
+	archive.Close()
+
+	// Records before checkpoint aren't read when opening, a torn tail after it is truncated.
+	fileName := filepath.Join(dir, FilePrefix+"0")
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal("Read archive file failed, err:", err)
+	}
+	data[checkpoint.Offset-1] ^= 0xff
+	if err = os.WriteFile(fileName, data[:len(data)-3], 0644); err != nil {
+		t.Fatal("Write archive file failed, err:", err)
+	}
+	archive, err = NewArchiveInDir(dir, 0, Options{Checkpoint: checkpoint})
+	if err != nil {
+		t.Fatal("Reopen archive with checkpoint failed, err:", err)
+	}
+	defer archive.Close()
+	recordLen := len((&Entry{Uuid: uuid.New().String()}).encodeRecord(CurrentVersion))
+	if archive.EntryCount() != 14 || archive.DroppedBytes() != int64(recordLen-3) {
+		t.Errorf("Archive has %d entries and dropped %d bytes, expect 14 and %d",
+			archive.EntryCount(), archive.DroppedBytes(), recordLen-3)
+	}
+
+	// Checkpoint beyond the end of file is ignored, all records are counted.
+	archive.Close()
+	_, err = NewArchiveInDir(dir, 0, Options{Checkpoint: Checkpoint{Entries: 100, Offset: archive.Size() + 1}})
+	if !errors.Is(err, ErrArchiveCorrupted) {
+		t.Error("All records should be validated without valid checkpoint, err:", err)
+	}
+}
+
+func TestArchive_Legacy(t *testing.T) {
+	dir := t.TempDir()
+	entries := make([]*Entry, 0, 10)
+	data := make([]byte, 0)
+	for i := 0; i < 10; i++ {
+		entry := &Entry{
+			Id:        uint64(i),
+			Uuid:      uuid.New().String(),
+			ExtraUuid: []string{uuid.New().String()},
+		}
+		entries = append(entries, entry)
+		data = append(data, entry.Encode()...)
+	}
+	err := os.WriteFile(filepath.Join(dir, "donkey_archive_0"), data, 0644)
+	if err != nil {
+		t.Fatal("Write legacy archive failed, err:", err)
+	}
+
+	archive, err := NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 1})
+	if err != nil {
+		t.Fatal("Open legacy archive failed, err:", err)
+	}
+	defer archive.Close()
+	if archive.Header().Version != LegacyVersion {
+		t.Error("Archive without header should be legacy")
+	}
+	// New entries of legacy archive are still in legacy format.
+	entries = append(entries, appendTestEntries(t, archive, 1, 1)...)
+	for _, entry := range entries {
+		e, err := archive.GetOneEntry(1)
+		if err != nil {
+			t.Fatal("Get one entry failed, err:", err)
+		}
+		if e.Id != entry.Id || e.Uuid != entry.Uuid || e.ExtraUuid[0] != entry.ExtraUuid[0] {
+			t.Error("Entry is different")
+		}
+	}
+}
+
+func TestArchive_RecoverTornTail(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewArchiveInDir(dir, 0, Options{})
+	if err != nil {
+		t.Fatal("New archive failed, err:", err)
+	}
+	appendTestEntries(t, archive, 10, 0)
+	archive.Close()
+
+	// The last record is written partly.
+	fileName := filepath.Join(dir, "donkey_archive_0")
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal("Stat archive failed, err:", err)
+	}
+	if err = os.Truncate(fileName, stat.Size()-3); err != nil {
+		t.Fatal("Truncate archive failed, err:", err)
+	}
+
+	archive, err = NewArchiveInDir(dir, 0, Options{})
+	if err != nil {
+		t.Fatal("Reopen archive failed, err:", err)
+	}
+	recordLen := len((&Entry{Uuid: uuid.New().String()}).encodeRecord(CurrentVersion))
+	if archive.DroppedBytes() != int64(recordLen-3) {
+		t.Errorf("Dropped %d bytes, expect %d", archive.DroppedBytes(), recordLen-3)
+	}
+	// Append after recovering, then all entries are readable.
+	appendTestEntries(t, archive, 1, 0)
+	archive.Close()
+	archive, err = NewArchiveInDir(dir, 0, Options{})
+	if err != nil {
+		t.Fatal("Reopen archive failed, err:", err)
+	}
+	defer archive.Close()
+	if archive.DroppedBytes() != 0 {
+		t.Error("Recovered archive should not drop bytes again")
+	}
+	for i := 0; i < 10; i++ {
+		if _, err = archive.GetOneEntry(0); err != nil {
+			t.Fatal("Get one entry failed, err:", err)
+		}
+	}
+	if _, err = archive.GetOneEntry(0); !errors.Is(err, ErrReadEndOfFile) {
+		t.Error("Read after last entry should be end of file, err:", err)
+	}
+}
+
+func TestArchive_Mutation(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 1})
+	if err != nil {
+		t.Fatal("New archive failed, err:", err)
+	}
+	entries := []*Entry{
+		{Id: 1, Op: OpInsert, Uuid: uuid.New().String(), ExtraUuid: []string{uuid.New().String()}},
+		{Id: 1, Op: OpUpdate, Version: 1, Uuid: uuid.New().String(), ExtraUuid: []string{uuid.New().String()}},
+		{Id: 1, Op: OpDelete, Version: 2},
+	}
+	if err = archive.AppendEntries(entries); err != nil {
+		t.Fatal("Append entries failed, err:", err)
+	}
+	archive.Close()
+
+	archive, err = NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 1})
+	if err != nil {
+		t.Fatal("Reopen archive failed, err:", err)
+	}
+	defer archive.Close()
+	if archive.EntryCount() != 3 {
+		t.Errorf("Archive has %d entries, expect 3", archive.EntryCount())
+	}
+	for _, entry := range entries {
+		e, err := archive.GetOneEntry(1)
+		if err != nil {
+			t.Fatal("Get one entry failed, err:", err)
+		}
+		if e.Id != entry.Id || e.Op != entry.Op || e.Version != entry.Version ||
+			e.Uuid != entry.Uuid || len(e.ExtraUuid) != len(entry.ExtraUuid) {
+			t.Errorf("Entry is different: %+v, expect %+v", e, entry)
+		}
+	}
+
+	// Archive of old version can't record mutations.
+	data := (&Header{Version: ChecksumVersion}).Encode()
+	if err = os.WriteFile(filepath.Join(dir, "donkey_archive_1"), data, 0644); err != nil {
+		t.Fatal("Write archive file failed, err:", err)
+	}
+	old, err := NewArchiveInDir(dir, 1, Options{})
+	if err != nil {
+		t.Fatal("Open old archive failed, err:", err)
+	}
+	defer old.Close()
+	if err = old.AppendOneEntry(&Entry{Id: 1, Op: OpDelete, Version: 1}); !errors.Is(err, ErrMutationNotSupported) {
+		t.Error("Old archive should not record delete, err:", err)
+	}
+}
+
+func TestArchive_OpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 1})
+	if err != nil {
+		t.Fatal("New archive failed, err:", err)
+	}
+	appendTestEntries(t, archive, 10, 1)
+	archive.Close()
+	fileName := filepath.Join(dir, FilePrefix+"0")
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal("Stat archive failed, err:", err)
+	}
+	if err = os.Truncate(fileName, stat.Size()-3); err != nil {
+		t.Fatal("Truncate archive failed, err:", err)
+	}
+
+	// Extra column number is read from header.
+	archive, err = OpenReadOnly(fileName, 0)
+	if err != nil {
+		t.Fatal("Open archive read only failed, err:", err)
+	}
+	defer archive.Close()
+	if archive.ExtraColumnNum() != 1 {
+		t.Errorf("Read only archive has %d extra columns", archive.ExtraColumnNum())
+	}
+	for i := 0; i < 9; i++ {
+		if _, err = archive.GetOneEntry(archive.ExtraColumnNum()); err != nil {
+			t.Fatal("Get one entry failed, err:", err)
+		}
+	}
+	if _, err = archive.GetOneEntry(archive.ExtraColumnNum()); !errors.Is(err, ErrArchiveIncomplete) {
+		t.Error("Torn tail should be incomplete, err:", err)
+	}
+	s, err := Scan(fileName, 0, nil)
+	if err != nil || s.Entries != 9 || s.TornBytes == 0 || s.Size != stat.Size()-3 {
+		t.Errorf("Scan read only archive get %+v, err: %v", s, err)
+	}
+	if stat, err = os.Stat(fileName); err != nil || stat.Size() != s.Size {
+		t.Error("Read only archive should not truncate file, err:", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, FilePrefix+"1")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Missing archive should not be created, err:", err)
+	}
+	if _, err = OpenReadOnly(filepath.Join(dir, FilePrefix+"1"), 0); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Open missing archive should fail, err:", err)
+	}
+}
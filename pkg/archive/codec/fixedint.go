@@ -35,3 +35,29 @@ func GetFixedUint64(data []byte, index int) FixedUint64 {
 	res[7] = data[index+7]
 	return *res
 }
+
+type FixedUint32 [4]byte
+
+func EncodeFixedUint32(n uint32) FixedUint32 {
+	res := new(FixedUint32)
+	res[0] = byte(n)
+	res[1] = byte(n >> 8)
+	res[2] = byte(n >> 16)
+	res[3] = byte(n >> 24)
+	return *res
+}
+
+func DecodeFixedUint32(n FixedUint32) uint32 {
+	res := uint32(n[0]) + (uint32(n[1]) << 8) +
+		(uint32(n[2]) << 16) + (uint32(n[3]) << 24)
+	return res
+}
+
+func GetFixedUint32(data []byte, index int) FixedUint32 {
+	res := new(FixedUint32)
+	res[0] = data[index]
+	res[1] = data[index+1]
+	res[2] = data[index+2]
+	res[3] = data[index+3]
+	return *res
+}
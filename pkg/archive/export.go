@@ -0,0 +1,106 @@
+package archive
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Formats of exported entries.
+const (
+	// FormatJSON is a JSON object per line.
+	FormatJSON = "json"
+	// FormatCSV is a CSV row per entry with a header row.
+	FormatCSV = "csv"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// EntryWriter writes entries of archive files as text.
+type EntryWriter interface {
+	// Write writes entry of archive file, offset is the offset of its record.
+	Write(fileName string, offset int64, entry *Entry) error
+	// Flush writes buffered data.
+	Flush() error
+}
+
+// NewEntryWriter returns writer of format. CSV has a column for every extra uuid.
+func NewEntryWriter(w io.Writer, format string, extraNum uint) (EntryWriter, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return &csvWriter{writer: csv.NewWriter(w), extraNum: extraNum}, nil
+	default:
+		return nil, fmt.Errorf("%w %q, it isn't %s or %s", ErrUnknownFormat, format, FormatJSON, FormatCSV)
+	}
+}
+
+// jsonEntry is entry in JSON, delete entry has no uuid.
+type jsonEntry struct {
+	File      string   `json:"file"`
+	Offset    int64    `json:"offset"`
+	Id        uint64   `json:"id"`
+	Op        string   `json:"op"`
+	Version   uint64   `json:"version"`
+	Uuid      string   `json:"uuid,omitempty"`
+	ExtraUuid []string `json:"extra-uuid,omitempty"`
+}
+
+type jsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonWriter) Write(fileName string, offset int64, entry *Entry) error {
+	return w.encoder.Encode(&jsonEntry{
+		File:      fileName,
+		Offset:    offset,
+		Id:        entry.Id,
+		Op:        entry.Op.String(),
+		Version:   entry.Version,
+		Uuid:      entry.Uuid,
+		ExtraUuid: entry.ExtraUuid,
+	})
+}
+
+func (w *jsonWriter) Flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	writer   *csv.Writer
+	extraNum uint
+	// headerWritten is true after the header row is written.
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(fileName string, offset int64, entry *Entry) error {
+	if !w.headerWritten {
+		header := []string{"file", "offset", "id", "op", "version", "uuid"}
+		for i := uint(0); i < w.extraNum; i++ {
+			header = append(header, "uuid_extra_"+strconv.Itoa(int(i)))
+		}
+		if err := w.writer.Write(header); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+	record := []string{fileName, strconv.FormatInt(offset, 10), strconv.FormatUint(entry.Id, 10),
+		entry.Op.String(), strconv.FormatUint(entry.Version, 10), entry.Uuid}
+	for i := uint(0); i < w.extraNum; i++ {
+		extra := ""
+		if int(i) < len(entry.ExtraUuid) {
+			extra = entry.ExtraUuid[i]
+		}
+		record = append(record, extra)
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
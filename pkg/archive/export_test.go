@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEntryWriter(t *testing.T) {
+	entries := []*Entry{
+		{Id: 1, Op: OpInsert, Uuid: "u1", ExtraUuid: []string{"e1", "e2"}},
+		{Id: 1, Op: OpDelete, Version: 1},
+	}
+
+	out := &bytes.Buffer{}
+	writer, err := NewEntryWriter(out, FormatJSON, 2)
+	if err != nil {
+		t.Fatal("New JSON writer failed, err:", err)
+	}
+	for i, entry := range entries {
+		if err = writer.Write("donkey_archive_0", int64(i*10), entry); err != nil {
+			t.Fatal("Write entry failed, err:", err)
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		t.Fatal("Flush failed, err:", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected JSON lines:\n%s", out)
+	}
+	var e jsonEntry
+	if err = json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal("Decode JSON line failed, err:", err)
+	}
+	if e.File != "donkey_archive_0" || e.Offset != 10 || e.Op != "delete" || e.Version != 1 || e.Uuid != "" {
+		t.Errorf("Unexpected JSON entry %+v", e)
+	}
+
+	out.Reset()
+	writer, err = NewEntryWriter(out, FormatCSV, 2)
+	if err != nil {
+		t.Fatal("New CSV writer failed, err:", err)
+	}
+	for i, entry := range entries {
+		if err = writer.Write("donkey_archive_0", int64(i*10), entry); err != nil {
+			t.Fatal("Write entry failed, err:", err)
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		t.Fatal("Flush failed, err:", err)
+	}
+	expect := "file,offset,id,op,version,uuid,uuid_extra_0,uuid_extra_1\n" +
+		"donkey_archive_0,0,1,insert,0,u1,e1,e2\n" +
+		"donkey_archive_0,10,1,delete,1,,,\n"
+	if out.String() != expect {
+		t.Errorf("Unexpected CSV:\n%s", out)
+	}
+
+	if _, err = NewEntryWriter(out, "xml", 0); !errors.Is(err, ErrUnknownFormat) {
+		t.Error("Unknown format should fail, err:", err)
+	}
+}
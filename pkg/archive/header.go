@@ -0,0 +1,138 @@
+package archive
+
+import (
+	"bytes"
+	"donkey/pkg/archive/codec"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	// LegacyVersion is the archive file without header, its records don't have checksum.
+	LegacyVersion = 0
+	// ChecksumVersion is the archive file with header.
+	// Every record is: var int length of payload | payload (Entry.Encode) | fixed crc32c of payload.
+	ChecksumVersion = 1
+	// MutationVersion adds operation and row version to payload:
+	// var int id | op | var int version | uuids (delete has no uuid).
+	MutationVersion = 2
+	// CurrentVersion is the version of new archive file.
+	CurrentVersion = MutationVersion
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported archive version")
+	// errTornHeader is a header which is not written completely, the file is shorter than the header.
+	// There is no record after it, so the file can be created again.
+	errTornHeader = errors.New("archive header is incomplete")
+)
+
+// maxRunIdSize is the max length of run id in header, bigger length must be corrupted.
+const maxRunIdSize = 1024
+
+// magic is the beginning of archive file which has header.
+var magic = []byte("DNKYARCH")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Header is the head of archive file:
+// magic | fixed version | fixed extra column num | var int length of run id | run id | fixed crc32c
+type Header struct {
+	Version        uint64
+	ExtraColumnNum uint64
+	// RunId is the id of the run which created the archive file.
+	RunId string
+}
+
+func (h *Header) Encode() []byte {
+	data := make([]byte, 0, 64)
+	data = append(data, magic...)
+	version := codec.EncodeFixedUint64(h.Version)
+	data = append(data, version[:]...)
+	extraColumnNum := codec.EncodeFixedUint64(h.ExtraColumnNum)
+	data = append(data, extraColumnNum[:]...)
+	data = append(data, codec.EncodeVarUint64(uint64(len(h.RunId)))...)
+	data = append(data, []byte(h.RunId)...)
+	crc := codec.EncodeFixedUint32(crc32.Checksum(data, crc32cTable))
+	data = append(data, crc[:]...)
+	return data
+}
+
+// readHeader reads header of archive file of size, and returns the length of header.
+// File doesn't begin with magic is a legacy archive, its header length is 0.
+// Only a file shorter than its header is torn, any other inconsistent header is corrupted.
+func readHeader(f *os.File, fileName string, size int64) (*Header, int64, error) {
+	data := make([]byte, 4096)
+	n, err := f.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		fmt.Printf("Read archive %s header failed, err: %s\n", fileName, err)
+		return nil, 0, err
+	}
+	data = data[:n]
+	corrupted := func(reason string) error {
+		return &CorruptedError{FileName: fileName, Offset: 0, Reason: reason}
+	}
+	if len(data) < len(magic) && bytes.HasPrefix(magic, data) {
+		return nil, 0, errTornHeader
+	}
+	if !bytes.HasPrefix(data, magic) {
+		if nearMagic(data) {
+			return nil, 0, corrupted("magic mismatch")
+		}
+		return &Header{Version: LegacyVersion}, 0, nil
+	}
+	index := len(magic)
+	if size < int64(index+16) {
+		return nil, 0, errTornHeader
+	}
+	h := &Header{}
+	h.Version = codec.DecodeFixedUint64(codec.GetFixedUint64(data, index))
+	index += 8
+	h.ExtraColumnNum = codec.DecodeFixedUint64(codec.GetFixedUint64(data, index))
+	index += 8
+	runIdLen, index, err := codec.GetVarUint64(data, index)
+	if err != nil {
+		if int64(len(data)) == size {
+			// Length of run id is cut by the end of file.
+			return nil, 0, errTornHeader
+		}
+		return nil, 0, corrupted("run id length is too long")
+	}
+	runIdSize := codec.DecodeVarUint64(runIdLen)
+	if len(runIdLen) > 9 || runIdSize > maxRunIdSize {
+		return nil, 0, corrupted(fmt.Sprintf("run id length %d is too large", runIdSize))
+	}
+	runIdEnd := uint64(index) + runIdSize
+	if int64(runIdEnd+4) > size {
+		return nil, 0, errTornHeader
+	}
+	h.RunId = string(data[index:runIdEnd])
+	index = int(runIdEnd)
+	crc := codec.DecodeFixedUint32(codec.GetFixedUint32(data, index))
+	if crc != crc32.Checksum(data[:index], crc32cTable) {
+		return nil, 0, corrupted("header checksum mismatch")
+	}
+	index += 4
+	if h.Version > CurrentVersion {
+		fmt.Printf("Archive %s version %d is not supported\n", fileName, h.Version)
+		return nil, 0, ErrUnsupportedVersion
+	}
+	return h, int64(index), nil
+}
+
+// nearMagic reports whether data begins with magic of a flipped byte, it's a corrupted header, not a legacy archive.
+func nearMagic(data []byte) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+	diff := 0
+	for i := range magic {
+		if data[i] != magic[i] {
+			diff++
+		}
+	}
+	return diff == 1
+}
@@ -0,0 +1,85 @@
+package archive
+
+import (
+	"errors"
+)
+
+// Stat is the content of an archive file.
+type Stat struct {
+	FileName string
+	Header   Header
+	// Size is the size of file, including torn tail.
+	Size    int64
+	Entries uint64
+	Inserts uint64
+	Updates uint64
+	Deletes uint64
+	// MinId and MaxId are only meaningful if there is any entry.
+	MinId uint64
+	MaxId uint64
+	// TornBytes is the size of incomplete record at the end of file.
+	TornBytes int64
+	// Corrupted is the first corrupted record, entries after it can't be read.
+	Corrupted *CorruptedError
+}
+
+// ReadOffset returns the offset of the record which GetOneEntry reads next.
+func (archive *Archive) ReadOffset() int64 {
+	return archive.readOffset - int64(len(archive.buffer))
+}
+
+// Scan reads every record of archive file without changing it, and validates them.
+// Fn is called with every entry and the offset of its record, scanning stops if fn returns an error.
+// Corrupted record is in stat instead of error, scanning stops there.
+func Scan(fileName string, extraNum uint, fn func(entry *Entry, offset int64) error) (*Stat, error) {
+	a, err := OpenReadOnly(fileName, extraNum)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	stat := &Stat{
+		FileName:  fileName,
+		Header:    a.Header(),
+		Size:      a.Size() + a.DroppedBytes(),
+		TornBytes: a.DroppedBytes(),
+	}
+	for {
+		offset := a.ReadOffset()
+		entry, err := a.GetOneEntry(a.ExtraColumnNum())
+		if errors.Is(err, ErrReadEndOfFile) {
+			return stat, nil
+		}
+		if errors.Is(err, ErrArchiveIncomplete) {
+			stat.TornBytes += stat.Size - offset
+			return stat, nil
+		}
+		corrupted := &CorruptedError{}
+		if errors.As(err, &corrupted) {
+			stat.Corrupted = corrupted
+			return stat, nil
+		}
+		if err != nil {
+			return stat, err
+		}
+		if stat.Entries == 0 || entry.Id < stat.MinId {
+			stat.MinId = entry.Id
+		}
+		if stat.Entries == 0 || entry.Id > stat.MaxId {
+			stat.MaxId = entry.Id
+		}
+		stat.Entries++
+		switch entry.Op {
+		case OpInsert:
+			stat.Inserts++
+		case OpUpdate:
+			stat.Updates++
+		case OpDelete:
+			stat.Deletes++
+		}
+		if fn != nil {
+			if err = fn(entry, offset); err != nil {
+				return stat, err
+			}
+		}
+	}
+}
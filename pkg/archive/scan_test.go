@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewArchiveInDir(dir, 0, Options{ExtraColumnNum: 1})
+	if err != nil {
+		t.Fatal("New archive failed, err:", err)
+	}
+	entries := []*Entry{
+		{Id: 7, Op: OpInsert, Uuid: uuid.New().String(), ExtraUuid: []string{uuid.New().String()}},
+		{Id: 3, Op: OpInsert, Uuid: uuid.New().String(), ExtraUuid: []string{uuid.New().String()}},
+		{Id: 7, Op: OpUpdate, Version: 1, Uuid: uuid.New().String(), ExtraUuid: []string{uuid.New().String()}},
+		{Id: 3, Op: OpDelete, Version: 1},
+		{Id: 9, Op: OpInsert, Uuid: uuid.New().String(), ExtraUuid: []string{uuid.New().String()}},
+	}
+	if err = archive.AppendEntries(entries); err != nil {
+		t.Fatal("Append entries failed, err:", err)
+	}
+	archive.Close()
+	fileName := filepath.Join(dir, FilePrefix+"0")
+
+	var offsets []int64
+	stat, err := Scan(fileName, 0, func(entry *Entry, offset int64) error {
+		offsets = append(offsets, offset)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Scan archive failed, err:", err)
+	}
+	if stat.Entries != 5 || stat.Inserts != 3 || stat.Updates != 1 || stat.Deletes != 1 ||
+		stat.MinId != 3 || stat.MaxId != 9 || stat.Corrupted != nil {
+		t.Errorf("Unexpected stat %+v", stat)
+	}
+	if info, err := os.Stat(fileName); err != nil || info.Size() != stat.Size {
+		t.Errorf("Stat size is %d, err: %v", stat.Size, err)
+	}
+
+	// Corrupt the second record, scanning stops at it.
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal("Read archive failed, err:", err)
+	}
+	data[offsets[1]+5] ^= 0xff
+	if err = os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal("Write archive failed, err:", err)
+	}
+	stat, err = Scan(fileName, 0, nil)
+	if err != nil {
+		t.Fatal("Scan corrupted archive failed, err:", err)
+	}
+	if stat.Entries != 1 || stat.Corrupted == nil || stat.Corrupted.Offset != offsets[1] ||
+		!errors.Is(stat.Corrupted, ErrArchiveCorrupted) {
+		t.Errorf("Unexpected stat of corrupted archive %+v", stat)
+	}
+}
@@ -1,35 +1,250 @@
 package config
 
-import "sync/atomic"
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
 
+// Config is the configuration of donkey.
+// Json tag is the key in config file, it's the same as command flag.
 type Config struct {
-	DbType         string
-	Host           string
-	Port           int
-	User           string
-	Pass           string
-	Database       string
-	InsertRows     uint64
-	FrontSQL       string
-	PostSQL        string
-	UniqueSyntax   string
-	RoutineNum     uint
-	InsertData     bool
-	CheckData      bool
-	InsertPackage  uint
-	ExtraColumnNum uint
-	InsertDelay    int64
-	TimeConsume    bool
+	Workload       string   `json:"workload"`
+	DbType         string   `json:"db-type"`
+	Host           string   `json:"host"`
+	Port           int      `json:"port"`
+	User           string   `json:"user"`
+	Pass           string   `json:"password"`
+	Database       string   `json:"db"`
+	InsertRows     uint64   `json:"rows"`
+	FrontSQL       string   `json:"front-SQL"`
+	PostSQL        string   `json:"post-SQL"`
+	UniqueSyntax   string   `json:"unique-syntax"`
+	RoutineNum     uint     `json:"routine-num"`
+	InsertData     bool     `json:"insert-data"`
+	CheckData      bool     `json:"check-data"`
+	ReverseCheck   bool     `json:"reverse-check"`
+	CheckBatch     uint     `json:"check-batch"`
+	InsertPackage  uint     `json:"insert-package"`
+	InsertMethod   string   `json:"insert-method"`
+	Isolation      string   `json:"isolation"`
+	TxStatements   uint     `json:"tx-statements"`
+	UpdatePercent  uint     `json:"update-percent"`
+	DeletePercent  uint     `json:"delete-percent"`
+	Accounts       uint     `json:"accounts"`
+	Seed           string   `json:"seed"`
+	ArchiveData    bool     `json:"archive-data"`
+	ExtraColumnNum uint     `json:"extra-column-num"`
+	InsertDelay    int64    `json:"insert-delay"`
+	Rate           uint64   `json:"rate"`
+	Duration       Duration `json:"duration"`
+	Schedule       Schedule `json:"schedule"`
+	TimeConsume    bool     `json:"time-consume"`
+	ReportInterval uint     `json:"report-interval"`
+	MetricsAddr    string   `json:"metrics-addr"`
+	Report         string   `json:"report"`
+	JUnitReport    string   `json:"junit-report"`
+	WorkDir        string   `json:"work-dir"`
 }
 
+// KeyError is an invalid value of config key.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("config key %q: %s", e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// CheckDbType returns an error if db type has no operator, it's nil if nothing checks db type.
+// Package operator imports config, so it sets CheckDbType to check its registered operators.
+var CheckDbType func(dbType string) error
+
+const (
+	// WorkloadInsert inserts (and updates, deletes) rows, then checks them with archives.
+	WorkloadInsert = "insert"
+	// WorkloadBank transfers balance between accounts, and checks total balance is constant.
+	WorkloadBank = "bank"
+)
+
+const (
+	// InsertMethodText sends every insert statement as text with values in it.
+	InsertMethodText = "text"
+	// InsertMethodPrepared prepares insert statement once, and binds values of every insert.
+	InsertMethodPrepared = "prepared"
+	// InsertMethodBulk loads rows in bulk, e.g. LOAD DATA LOCAL INFILE of MySQL or COPY of Postgres.
+	InsertMethodBulk = "bulk"
+)
+
+// isolationLevels are values of isolation, empty is the default level of database.
+var isolationLevels = map[string]sql.IsolationLevel{
+	"":                 sql.LevelDefault,
+	"read-uncommitted": sql.LevelReadUncommitted,
+	"read-committed":   sql.LevelReadCommitted,
+	"repeatable-read":  sql.LevelRepeatableRead,
+	"serializable":     sql.LevelSerializable,
+}
+
+// MaxCheckBatch is the max number of ids checked in one query.
+// Every id is a bind variable, databases limit the number of them.
+const MaxCheckBatch = 10000
+
+// MaxPlaceholders is the max number of bind variables of a prepared statement,
+// MySQL and Postgres both limit it to 65535.
+const MaxPlaceholders = 65535
+
 var globalCfg atomic.Value
 
+// DefaultConfig returns config with default values of all keys.
+func DefaultConfig() *Config {
+	return &Config{
+		Workload:       WorkloadInsert,
+		InsertMethod:   InsertMethodText,
+		DbType:         "mysql",
+		Host:           "127.0.0.1",
+		Port:           3306,
+		User:           "root",
+		Database:       "my_donkey",
+		InsertData:     true,
+		CheckData:      true,
+		WorkDir:        "./",
+		Accounts:       100,
+		ArchiveData:    true,
+		ReportInterval: 10,
+	}
+}
+
 // InitializeConfig initialize the global config handler.
-func InitializeConfig(enforceCmdArgs func(*Config)) {
-	cfg := Config{}
+// Empty configFile means there is no config file.
+func InitializeConfig(configFile string, enforceCmdArgs func(*Config)) error {
+	cfg := DefaultConfig()
+	if configFile != "" {
+		err := LoadConfigFile(configFile, cfg)
+		if err != nil {
+			return err
+		}
+	}
 	// Use command config cover config file.
-	enforceCmdArgs(&cfg)
-	StoreGlobalConfig(&cfg)
+	enforceCmdArgs(cfg)
+	cfg.adjust()
+	err := cfg.Validate()
+	if err != nil {
+		return err
+	}
+	StoreGlobalConfig(cfg)
+	return nil
+}
+
+// Mixed reports whether routines update and delete rows besides inserting.
+func (cfg *Config) Mixed() bool {
+	return cfg.UpdatePercent+cfg.DeletePercent != 0
+}
+
+// IsolationLevel returns isolation level of insert transaction.
+func (cfg *Config) IsolationLevel() sql.IsolationLevel {
+	return isolationLevels[cfg.Isolation]
+}
+
+// adjust makes 0 of some keys to its meaning.
+func (cfg *Config) adjust() {
+	// 0/1 both single routine
+	if cfg.RoutineNum == 0 {
+		cfg.RoutineNum = 1
+	}
+	// 0/1 both single row
+	if cfg.InsertPackage == 0 {
+		cfg.InsertPackage = 1
+	}
+	// Isolation level needs explicit transaction
+	if cfg.Isolation != "" && cfg.TxStatements == 0 {
+		cfg.TxStatements = 1
+	}
+}
+
+// Validate checks values of config, the error is a *KeyError.
+func (cfg *Config) Validate() error {
+	if cfg.Workload != WorkloadInsert && cfg.Workload != WorkloadBank {
+		return &KeyError{Key: "workload", Err: fmt.Errorf("%q isn't %s or %s",
+			cfg.Workload, WorkloadInsert, WorkloadBank)}
+	}
+	if cfg.Workload == WorkloadBank && cfg.Accounts < 2 {
+		return &KeyError{Key: "accounts", Err: fmt.Errorf("%d is less than 2", cfg.Accounts)}
+	}
+	if cfg.DbType == "" {
+		return &KeyError{Key: "db-type", Err: errors.New("must not be empty")}
+	}
+	if CheckDbType != nil {
+		if err := CheckDbType(cfg.DbType); err != nil {
+			return &KeyError{Key: "db-type", Err: err}
+		}
+	}
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return &KeyError{Key: "port", Err: fmt.Errorf("%d is out of range [1, 65535]", cfg.Port)}
+	}
+	if cfg.Database == "" {
+		return &KeyError{Key: "db", Err: errors.New("must not be empty")}
+	}
+	if cfg.CheckBatch > MaxCheckBatch {
+		return &KeyError{Key: "check-batch", Err: fmt.Errorf("%d is bigger than %d", cfg.CheckBatch, MaxCheckBatch)}
+	}
+	if cfg.UpdatePercent+cfg.DeletePercent >= 100 {
+		return &KeyError{Key: "update-percent", Err: fmt.Errorf("update %d%% + delete %d%% must be less than 100%%",
+			cfg.UpdatePercent, cfg.DeletePercent)}
+	}
+	if !cfg.ArchiveData && cfg.Seed == "" {
+		return &KeyError{Key: "archive-data", Err: errors.New("rows without archive can only be checked with seed")}
+	}
+	if !cfg.ArchiveData && cfg.Mixed() {
+		return &KeyError{Key: "archive-data", Err: errors.New("update and delete must be archived")}
+	}
+	if cfg.InsertMethod != InsertMethodText && cfg.InsertMethod != InsertMethodPrepared &&
+		cfg.InsertMethod != InsertMethodBulk {
+		return &KeyError{Key: "insert-method", Err: fmt.Errorf("%q isn't %s, %s or %s",
+			cfg.InsertMethod, InsertMethodText, InsertMethodPrepared, InsertMethodBulk)}
+	}
+	if cfg.InsertMethod == InsertMethodPrepared {
+		if err := cfg.validatePlaceholders(); err != nil {
+			return err
+		}
+	}
+	if _, ok := isolationLevels[cfg.Isolation]; !ok {
+		return &KeyError{Key: "isolation", Err: fmt.Errorf("unknown isolation level %q", cfg.Isolation)}
+	}
+	if cfg.InsertDelay < 0 {
+		return &KeyError{Key: "insert-delay", Err: fmt.Errorf("%d is negative", cfg.InsertDelay)}
+	}
+	if cfg.Duration < 0 {
+		return &KeyError{Key: "duration", Err: fmt.Errorf("%s is negative", cfg.Duration)}
+	}
+	if err := cfg.Schedule.validate(cfg.RoutineNum); err != nil {
+		return &KeyError{Key: "schedule", Err: err}
+	}
+	return nil
+}
+
+// validatePlaceholders checks every prepared insert binds at most MaxPlaceholders variables,
+// every row binds id, uuid and extra columns.
+func (cfg *Config) validatePlaceholders() error {
+	columns := uint64(cfg.ExtraColumnNum) + 2
+	if n := uint64(cfg.InsertPackage) * columns; n > MaxPlaceholders {
+		return &KeyError{Key: "insert-package", Err: fmt.Errorf(
+			"%d rows of %d columns need %d placeholders in prepared insert, more than %d",
+			cfg.InsertPackage, columns, n, MaxPlaceholders)}
+	}
+	for i, stage := range cfg.Schedule {
+		if n := uint64(stage.InsertPackage) * columns; n > MaxPlaceholders {
+			return &KeyError{Key: "schedule", Err: fmt.Errorf(
+				"stage %d: insert-package %d rows of %d columns need %d placeholders in prepared insert, more than %d",
+				i, stage.InsertPackage, columns, n, MaxPlaceholders)}
+		}
+	}
+	return nil
 }
 
 // GetGlobalConfig returns the global configuration for this server.
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	fileName := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(fileName, []byte(content), 0666)
+	if err != nil {
+		t.Fatal("Write config file failed, err:", err)
+	}
+	return fileName
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	files := map[string]string{
+		"donkey.yaml": "db-type: postgres\nport: 5432\nrows: 100\ncheck-data: false\n",
+		"donkey.toml": "db-type = \"postgres\"\nport = 5432\nrows = 100\ncheck-data = false\n",
+		"donkey.json": `{"db-type": "postgres", "port": 5432, "rows": 100, "check-data": false}`,
+	}
+	for name, content := range files {
+		cfg := DefaultConfig()
+		err := LoadConfigFile(writeConfigFile(t, name, content), cfg)
+		if err != nil {
+			t.Errorf("Load %s failed, err: %s", name, err)
+			continue
+		}
+		if cfg.DbType != "postgres" || cfg.Port != 5432 || cfg.InsertRows != 100 || cfg.CheckData {
+			t.Errorf("Load %s get wrong config: %+v", name, cfg)
+		}
+		if cfg.Host != "127.0.0.1" || !cfg.InsertData {
+			t.Errorf("Load %s changes keys not in file: %+v", name, cfg)
+		}
+	}
+}
+
+func TestLoadConfigFile_BadKey(t *testing.T) {
+	files := map[string]string{
+		"unknown.yaml": "hots: 127.0.0.1\n",
+		"type.toml":    "port = \"3306\"\n",
+		"range.json":   `{"rows": -1}`,
+	}
+	keys := map[string]string{
+		"unknown.yaml": "hots",
+		"type.toml":    "port",
+		"range.json":   "rows",
+	}
+	for name, content := range files {
+		err := LoadConfigFile(writeConfigFile(t, name, content), DefaultConfig())
+		keyErr := &KeyError{}
+		if !errors.As(err, &keyErr) {
+			t.Errorf("Load %s returns %v, expect KeyError", name, err)
+			continue
+		}
+		if keyErr.Key != keys[name] {
+			t.Errorf("Load %s reports key %s, expect %s", name, keyErr.Key, keys[name])
+		}
+	}
+}
+
+func TestInitializeConfig(t *testing.T) {
+	fileName := writeConfigFile(t, "donkey.yaml", "host: 10.0.0.1\nport: 4000\n")
+	err := InitializeConfig(fileName, func(cfg *Config) {
+		cfg.Port = 4001
+	})
+	if err != nil {
+		t.Fatal("Init config failed, err:", err)
+	}
+	cfg := GetGlobalConfig()
+	if cfg.Host != "10.0.0.1" {
+		t.Errorf("Host is %s, expect it from config file", cfg.Host)
+	}
+	if cfg.Port != 4001 {
+		t.Errorf("Port is %d, expect command config cover config file", cfg.Port)
+	}
+	if cfg.RoutineNum != 1 || cfg.InsertPackage != 1 {
+		t.Errorf("Routine num %d & insert package %d should be adjusted to 1",
+			cfg.RoutineNum, cfg.InsertPackage)
+	}
+
+	err = InitializeConfig(fileName, func(cfg *Config) {
+		cfg.Port = 0
+	})
+	keyErr := &KeyError{}
+	if !errors.As(err, &keyErr) || keyErr.Key != "port" {
+		t.Errorf("Invalid port returns %v, expect KeyError of port", err)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	CheckDbType = func(dbType string) error {
+		if dbType != "mysql" {
+			return errors.New("unknown db type")
+		}
+		return nil
+	}
+	defer func() {
+		CheckDbType = nil
+	}()
+	cases := map[string]func(cfg *Config){
+		"db-type": func(cfg *Config) {
+			cfg.DbType = "oracle"
+		},
+		"workload": func(cfg *Config) {
+			cfg.Workload = "transfer"
+		},
+		"accounts": func(cfg *Config) {
+			cfg.Workload = WorkloadBank
+			cfg.Accounts = 1
+		},
+		"update-percent": func(cfg *Config) {
+			cfg.UpdatePercent = 60
+			cfg.DeletePercent = 40
+		},
+		"insert-method": func(cfg *Config) {
+			cfg.InsertMethod = "binary"
+		},
+		"archive-data": func(cfg *Config) {
+			cfg.ArchiveData = false
+		},
+		"duration": func(cfg *Config) {
+			cfg.Duration = -1
+		},
+		"insert-package": func(cfg *Config) {
+			cfg.InsertMethod = InsertMethodPrepared
+			cfg.ExtraColumnNum = 8
+			cfg.InsertPackage = 6554
+		},
+	}
+	for key, set := range cases {
+		cfg := DefaultConfig()
+		set(cfg)
+		err := cfg.Validate()
+		keyErr := &KeyError{}
+		if !errors.As(err, &keyErr) || keyErr.Key != key {
+			t.Errorf("Invalid %s returns %v, expect KeyError of it", key, err)
+		}
+	}
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Error("Default config should be valid, err:", err)
+	}
+	cfg := DefaultConfig()
+	cfg.InsertMethod = InsertMethodPrepared
+	cfg.ExtraColumnNum = 8
+	cfg.InsertPackage = 6553
+	if err := cfg.Validate(); err != nil {
+		t.Error("Prepared insert of 65530 placeholders should be valid, err:", err)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	var schedule Schedule
+	err := schedule.Set("10m:routine-num=4,insert-delay=100; 2h:insert-package=50,rate=500;30m")
+	if err != nil {
+		t.Fatal("Parse schedule failed, err:", err)
+	}
+	if len(schedule) != 3 || time.Duration(schedule[0].Duration) != 10*time.Minute ||
+		schedule[0].RoutineNum != 4 || *schedule[0].InsertDelay != 100 ||
+		schedule[1].InsertPackage != 50 || schedule[1].Rate != 500 || schedule[1].InsertDelay != nil {
+		t.Errorf("Unexpected schedule %+v", schedule)
+	}
+	if s := schedule.String(); s != "10m0s:routine-num=4,insert-delay=100;2h0m0s:insert-package=50,rate=500;30m0s" {
+		t.Errorf("Schedule is printed as %s", s)
+	}
+	for _, bad := range []string{"10x", "10m:routines=4", "10m:routine-num"} {
+		if err = schedule.Set(bad); err == nil {
+			t.Errorf("Schedule %q should be invalid", bad)
+		}
+	}
+
+	files := map[string]string{
+		"list.yaml":   "routine-num: 8\nduration: 3h\nschedule:\n  - duration: 10m\n    routine-num: 2\n  - duration: 1h\n",
+		"string.toml": "routine-num = 8\nduration = \"3h\"\nschedule = \"10m:routine-num=2;1h\"\n",
+	}
+	for name, content := range files {
+		cfg := DefaultConfig()
+		err = LoadConfigFile(writeConfigFile(t, name, content), cfg)
+		if err != nil {
+			t.Errorf("Load %s failed, err: %s", name, err)
+			continue
+		}
+		if time.Duration(cfg.Duration) != 3*time.Hour || len(cfg.Schedule) != 2 ||
+			cfg.Schedule[0].RoutineNum != 2 || time.Duration(cfg.Schedule[1].Duration) != time.Hour {
+			t.Errorf("Load %s get wrong schedule: %+v", name, cfg.Schedule)
+		}
+		if err = cfg.Validate(); err != nil {
+			t.Errorf("Schedule of %s should be valid, err: %s", name, err)
+		}
+	}
+	err = LoadConfigFile(writeConfigFile(t, "bad.yaml", "schedule:\n  - duration: 10m\n    routines: 2\n"), DefaultConfig())
+	keyErr := &KeyError{}
+	if !errors.As(err, &keyErr) || keyErr.Key != "schedule" {
+		t.Errorf("Unknown key of stage returns %v, expect KeyError of schedule", err)
+	}
+
+	for _, schedule := range []string{"10m:routine-num=2;0s;1h", "10m:routine-num=5", "10m:insert-delay=-1"} {
+		cfg := DefaultConfig()
+		cfg.RoutineNum = 4
+		if err = cfg.Schedule.Set(schedule); err != nil {
+			t.Fatal("Parse schedule failed, err:", err)
+		}
+		if err = cfg.Validate(); !errors.As(err, &keyErr) || keyErr.Key != "schedule" {
+			t.Errorf("Invalid schedule %q returns %v, expect KeyError of schedule", schedule, err)
+		}
+	}
+}
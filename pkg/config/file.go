@@ -0,0 +1,86 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnknownConfigFormat = errors.New("unknown config file format")
+	ErrUnknownConfigKey    = errors.New("unknown key")
+)
+
+// LoadConfigFile loads config file to cfg, keys not in file keep its value.
+// Format is decided by extension of file: .yaml/.yml, .toml or .json.
+func LoadConfigFile(fileName string, cfg *Config) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		fmt.Printf("Read config file %s failed, err: %s\n", fileName, err)
+		return err
+	}
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		_, err = toml.Decode(string(data), &values)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	default:
+		fmt.Println("Unknown config file format:", fileName)
+		return ErrUnknownConfigFormat
+	}
+	if err != nil {
+		fmt.Printf("Parse config file %s failed, err: %s\n", fileName, err)
+		return err
+	}
+	return setConfigValues(cfg, values)
+}
+
+// setConfigValues sets decoded values of config file to cfg.
+// Every format is decoded to generic values first, then converted by json,
+// so all formats have the same keys and the same errors.
+func setConfigValues(cfg *Config, values map[string]interface{}) error {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(*cfg)
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key != "" && key != "-" {
+			fields[key] = i
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	v := reflect.ValueOf(cfg).Elem()
+	for _, key := range keys {
+		index, ok := fields[key]
+		if !ok {
+			return &KeyError{Key: key, Err: ErrUnknownConfigKey}
+		}
+		data, err := json.Marshal(values[key])
+		if err != nil {
+			return &KeyError{Key: key, Err: err}
+		}
+		err = json.Unmarshal(data, v.Field(index).Addr().Interface())
+		if err != nil {
+			return &KeyError{Key: key, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string in config file and command line, e.g. "1h30m".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set parses duration of command line.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"1h30m\", err: %w", err)
+	}
+	return d.Set(s)
+}
+
+// Stage is a stage of insert schedule. Keys not set in stage are taken from config.
+type Stage struct {
+	// Duration is how long the stage runs, 0 runs until the end of insert (only for the last stage).
+	Duration Duration `json:"duration"`
+	// RoutineNum is the number of running routines, 0 is all routines of routine-num.
+	RoutineNum    uint   `json:"routine-num"`
+	InsertDelay   *int64 `json:"insert-delay,omitempty"`
+	InsertPackage uint   `json:"insert-package"`
+	Rate          uint64 `json:"rate"`
+}
+
+// Schedule is stages of insert phase in order.
+// In command line (or as a string in config file), stages are separated by ';',
+// and every stage is "duration:key=value,key=value", e.g. "10m:routine-num=4,insert-delay=100;2h".
+type Schedule []Stage
+
+func (s Schedule) String() string {
+	stages := make([]string, 0, len(s))
+	for _, stage := range s {
+		var keys []string
+		if stage.RoutineNum != 0 {
+			keys = append(keys, fmt.Sprintf("routine-num=%d", stage.RoutineNum))
+		}
+		if stage.InsertDelay != nil {
+			keys = append(keys, fmt.Sprintf("insert-delay=%d", *stage.InsertDelay))
+		}
+		if stage.InsertPackage != 0 {
+			keys = append(keys, fmt.Sprintf("insert-package=%d", stage.InsertPackage))
+		}
+		if stage.Rate != 0 {
+			keys = append(keys, fmt.Sprintf("rate=%d", stage.Rate))
+		}
+		text := stage.Duration.String()
+		if len(keys) != 0 {
+			text += ":" + strings.Join(keys, ",")
+		}
+		stages = append(stages, text)
+	}
+	return strings.Join(stages, ";")
+}
+
+// Set parses schedule of command line.
+func (s *Schedule) Set(text string) error {
+	var schedule Schedule
+	for _, field := range strings.Split(text, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		stage, err := parseStage(field)
+		if err != nil {
+			return err
+		}
+		schedule = append(schedule, stage)
+	}
+	*s = schedule
+	return nil
+}
+
+func parseStage(text string) (Stage, error) {
+	stage := Stage{}
+	duration, keys, _ := strings.Cut(text, ":")
+	if err := stage.Duration.Set(strings.TrimSpace(duration)); err != nil {
+		return stage, fmt.Errorf("stage %q: %w", text, err)
+	}
+	for _, kv := range strings.Split(keys, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return stage, fmt.Errorf("stage %q: %q isn't key=value", text, kv)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "routine-num":
+			var v uint64
+			v, err = strconv.ParseUint(value, 10, 32)
+			stage.RoutineNum = uint(v)
+		case "insert-delay":
+			var v int64
+			v, err = strconv.ParseInt(value, 10, 64)
+			stage.InsertDelay = &v
+		case "insert-package":
+			var v uint64
+			v, err = strconv.ParseUint(value, 10, 32)
+			stage.InsertPackage = uint(v)
+		case "rate":
+			stage.Rate, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return stage, fmt.Errorf("stage %q: %w %q", text, ErrUnknownConfigKey, key)
+		}
+		if err != nil {
+			return stage, fmt.Errorf("stage %q: %s: %w", text, key, err)
+		}
+	}
+	return stage, nil
+}
+
+// UnmarshalJSON decodes schedule of config file, it's a list of stages or a string of command line format.
+func (s *Schedule) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return s.Set(text)
+	}
+	var stages []Stage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&stages); err != nil {
+		return err
+	}
+	*s = stages
+	return nil
+}
+
+// validate checks stages of schedule, routines of stages are taken from routineNum routines.
+func (s Schedule) validate(routineNum uint) error {
+	for i, stage := range s {
+		switch {
+		case stage.Duration < 0:
+			return fmt.Errorf("stage %d: duration %s is negative", i, stage.Duration)
+		case stage.Duration == 0 && i != len(s)-1:
+			return fmt.Errorf("stage %d: only the last stage can run without duration", i)
+		case stage.RoutineNum > routineNum:
+			return fmt.Errorf("stage %d: routine-num %d is bigger than routine-num %d of config",
+				i, stage.RoutineNum, routineNum)
+		case stage.InsertDelay != nil && *stage.InsertDelay < 0:
+			return fmt.Errorf("stage %d: insert-delay %d is negative", i, *stage.InsertDelay)
+		}
+	}
+	return nil
+}
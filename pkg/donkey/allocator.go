@@ -0,0 +1,110 @@
+package donkey
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// leaseClaims is the number of claims in a lease, a routine takes ids from the shared counter once per lease.
+const leaseClaims = 16
+
+// idAllocator leases blocks of ids to routines from a shared counter.
+// Leasing is one atomic add, so routines never spin on the counter.
+type idAllocator struct {
+	next uint64
+	// end is the first id not to insert, it's ignored without row limit.
+	end     uint64
+	limited bool
+}
+
+// newIdAllocator returns allocator of rows ids from first, 0 rows is infinity.
+func newIdAllocator(first, rows uint64) *idAllocator {
+	return &idAllocator{
+		next:    first,
+		end:     first + rows,
+		limited: rows != 0,
+	}
+}
+
+// lease takes at most n ids, it returns the first id and the number of ids.
+// The number is less than n only at the end of row limit, and 0 if all ids are leased.
+func (a *idAllocator) lease(n uint64) (uint64, uint64) {
+	first := atomic.AddUint64(&a.next, n) - n
+	if !a.limited {
+		return first, n
+	}
+	if first >= a.end {
+		return 0, 0
+	}
+	if first+n > a.end {
+		n = a.end - first
+	}
+	return first, n
+}
+
+// idLease is ids leased by a routine, they are taken in order, so ids of a routine are ascending.
+type idLease struct {
+	a *idAllocator
+	// claims is the number of takes leased at once.
+	claims uint64
+	next   uint64
+	end    uint64
+}
+
+func newIdLease(a *idAllocator, claims uint64) *idLease {
+	return &idLease{a: a, claims: claims}
+}
+
+// take takes at most n ids, it leases claims × n ids if the current block is used up.
+// It returns the first id and the number of ids, 0 if all ids are leased.
+func (l *idLease) take(n uint64) (uint64, uint64) {
+	if l.next == l.end {
+		first, count := l.a.lease(n * l.claims)
+		if count == 0 {
+			return 0, 0
+		}
+		l.next, l.end = first, first+count
+	}
+	if l.end-l.next < n {
+		n = l.end - l.next
+	}
+	first := l.next
+	l.next += n
+	return first, n
+}
+
+// remaining returns ids leased but not taken, they are never inserted.
+func (l *idLease) remaining() IdRange {
+	return IdRange{First: l.next, End: l.end}
+}
+
+// insertProgress prints progress every 10% of rows, or every 10000 rows without row limit.
+type insertProgress struct {
+	rows  uint64
+	step  uint64
+	count uint64
+}
+
+func newInsertProgress(rows uint64) *insertProgress {
+	step := rows / 10
+	if rows == 0 {
+		step = 10000
+	}
+	if step == 0 {
+		step = 1
+	}
+	return &insertProgress{rows: rows, step: step}
+}
+
+// add adds n tried rows, and prints progress if it crosses a step.
+func (p *insertProgress) add(n uint64) {
+	count := atomic.AddUint64(&p.count, n)
+	if count/p.step == (count-n)/p.step {
+		return
+	}
+	if p.rows == 0 {
+		fmt.Printf("Insert count: (%d/♾️)\n", count)
+	} else {
+		fmt.Printf("Insert progress: %d%% - (%d/%d)\n", count*100/p.rows, count, p.rows)
+	}
+}
@@ -0,0 +1,46 @@
+package donkey
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestIdAllocator_Limit(t *testing.T) {
+	a := newIdAllocator(100, 1000)
+	taken := make([]uint64, 8)
+	wg := sync.WaitGroup{}
+	for i := range taken {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lease := newIdLease(a, leaseClaims)
+			for {
+				first, count := lease.take(7)
+				if count == 0 {
+					return
+				}
+				if first < 100 || first+count > 1100 {
+					t.Errorf("Ids [%d, %d) are out of range", first, first+count)
+				}
+				taken[i] += count
+			}
+		}(i)
+	}
+	wg.Wait()
+	total := uint64(0)
+	for _, n := range taken {
+		total += n
+	}
+	if total != 1000 {
+		t.Errorf("Routines take %d ids, expect 1000", total)
+	}
+}
+
+func TestRunner_FewRows(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertRows = 7
+	r := runDonkey(t, cfg)
+	if count := countRows(t, r); count != cfg.InsertRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows)
+	}
+}
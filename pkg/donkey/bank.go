@@ -0,0 +1,231 @@
+package donkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+// initialBalance is the balance of every new account.
+const initialBalance = 1000
+
+// maxTransferAmount is the max amount of a transfer.
+const maxTransferAmount = 100
+
+// accountsPackage is the number of accounts in once insert.
+const accountsPackage = 1000
+
+var (
+	ErrBalanceViolated     = errors.New("total balance of accounts is changed")
+	ErrDifferentAccountNum = errors.New("different account number")
+)
+
+// bankStat is the result of bank workload.
+type bankStat struct {
+	committed  uint64
+	failed     uint64
+	reads      uint64
+	violations uint64
+}
+
+// Bank runs bank workload. Routines transfer random amount between random accounts in transactions,
+// and a reader routine checks total balance is constant all the time.
+// Transfers stop when ctx is canceled, number of transfers reaches rows of config, or duration (schedule)
+// of config ends, then total balance is checked once more. It returns ErrBalanceViolated if total balance is ever wrong.
+func (r *Runner) Bank(ctx context.Context) (err error) {
+	phase := r.beginPhase("bank")
+	defer r.endPhase(phase, &err)
+	cfg := r.cfg
+	err = r.connectTestingDb()
+	if err != nil {
+		return err
+	}
+	err = r.initAccounts()
+	if err != nil {
+		return err
+	}
+	expected := int64(cfg.Accounts) * initialBalance
+	fmt.Printf("Bank transferring between %d accounts, total balance is %d\n", cfg.Accounts, expected)
+
+	stopReport := r.startReport("bank")
+	defer stopReport()
+	sched, ctx, stopSchedule := r.startSchedule(ctx)
+	defer stopSchedule()
+	stat := &bankStat{}
+	transferCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	// Reader stops after all transfers stop.
+	readerCtx, stopReader := context.WithCancel(context.Background())
+	defer stopReader()
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for readerCtx.Err() == nil {
+			r.checkBalance(readerCtx, expected, stat)
+			sleepContext(readerCtx, time.Duration(cfg.InsertDelay)*time.Millisecond)
+		}
+	}()
+
+	transfers := uint64(0)
+	wg := sync.WaitGroup{}
+	wg.Add(int(cfg.RoutineNum))
+	for i := 0; i < int(cfg.RoutineNum); i++ {
+		go func(routineId int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(routineId)))
+			for sched.wait(transferCtx, routineId) {
+				// With rate, start is the intended start time and latency is measured from it.
+				// A transfer is charged as a row.
+				start, ok := sched.stage().begin(transferCtx, 1)
+				if !ok {
+					break
+				}
+				n := atomic.AddUint64(&transfers, 1)
+				if cfg.InsertRows != 0 && n > cfg.InsertRows {
+					stop()
+					break
+				}
+				from := uint64(rnd.Intn(int(cfg.Accounts)))
+				to := uint64(rnd.Intn(int(cfg.Accounts) - 1))
+				if to >= from {
+					to++
+				}
+				amount := rnd.Int63n(maxTransferAmount) + 1
+				err := r.transfer(routineId, from, to, amount)
+				r.record("transfer", start, err == nil)
+				if err != nil {
+					atomic.AddUint64(&stat.failed, 1)
+					r.metrics.insertErrors.With("transfer").Inc()
+					zlog.WarnF("Routine %d transfer %d from account [%d] to [%d] failed, err: %s",
+						routineId, amount, from, to, err)
+				} else {
+					atomic.AddUint64(&stat.committed, 1)
+				}
+				sleepContext(transferCtx, sched.stage().insertDelay)
+			}
+		}(i)
+	}
+	wg.Wait()
+	stopSchedule()
+	stopReader()
+	<-readerDone
+	// All transfers are finished, total balance must be the same.
+	r.checkBalance(context.Background(), expected, stat)
+	stopReport()
+
+	fmt.Println()
+	fmt.Printf("Bank transfers: committed %d, failed %d. Total balance read %d times, %d violations\n",
+		stat.committed, stat.failed, stat.reads, stat.violations)
+	zlog.InfoF("Bank transfers: committed %d, failed %d. Total balance read %d times, %d violations",
+		stat.committed, stat.failed, stat.reads, stat.violations)
+	phase.Counts["committed"] = stat.committed
+	phase.Counts["failed"] = stat.failed
+	phase.Counts["reads"] = stat.reads
+	phase.Counts["violations"] = stat.violations
+	if stat.violations != 0 {
+		fmt.Println("Bank check failed...")
+		return ErrBalanceViolated
+	}
+	fmt.Println("Bank check success!")
+	return nil
+}
+
+// initAccounts creates accounts table, and inserts accounts if it's empty.
+// Existing accounts table must have accounts of config.
+func (r *Runner) initAccounts() error {
+	db := r.dbs[0]
+	_, err := db.Exec(r.op.CreateAccountsSQL())
+	if err != nil {
+		fmt.Println("Create accounts table failed, err:", err)
+		return err
+	}
+	count, _, err := r.totalBalance(context.Background())
+	if err != nil {
+		fmt.Println("Get total balance failed, err:", err)
+		return err
+	}
+	if count == 0 {
+		for first := uint64(0); first < uint64(r.cfg.Accounts); first += accountsPackage {
+			n := uint64(r.cfg.Accounts) - first
+			if n > accountsPackage {
+				n = accountsPackage
+			}
+			_, err = db.Exec(r.op.InsertAccountsSQL(first, n, initialBalance))
+			if err != nil {
+				fmt.Println("Insert accounts failed, err:", err)
+				return err
+			}
+		}
+	} else if count != uint64(r.cfg.Accounts) {
+		fmt.Printf("Accounts table has %d accounts, config is %d\n", count, r.cfg.Accounts)
+		return ErrDifferentAccountNum
+	}
+	return nil
+}
+
+// transfer moves amount from an account to another in a transaction.
+// Accounts are updated in id order, so transfers don't deadlock each other.
+func (r *Runner) transfer(routineId int, from, to uint64, amount int64) error {
+	// Don't use ctx, canceling shouldn't break a transaction in the middle.
+	tx, err := r.dbs[routineId].Begin()
+	if err != nil {
+		return err
+	}
+	first, second := from, to
+	firstAmount, secondAmount := -amount, amount
+	if first > second {
+		first, second = second, first
+		firstAmount, secondAmount = secondAmount, firstAmount
+	}
+	_, err = tx.Exec(r.op.AddBalanceSQL(), firstAmount, first)
+	if err == nil {
+		_, err = tx.Exec(r.op.AddBalanceSQL(), secondAmount, second)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// totalBalance returns number of accounts and sum of their balance.
+func (r *Runner) totalBalance(ctx context.Context) (uint64, int64, error) {
+	count, total := uint64(0), int64(0)
+	err := r.dbs[0].QueryRowContext(ctx, r.op.TotalBalanceSQL()).Scan(&count, &total)
+	return count, total, err
+}
+
+// checkBalance reads total balance once, and reports it if it isn't expected.
+// It returns ErrBalanceViolated for a violation.
+func (r *Runner) checkBalance(ctx context.Context, expected int64, stat *bankStat) error {
+	start := time.Now()
+	count, total, err := r.totalBalance(ctx)
+	if ctx.Err() == nil {
+		r.record("balance-read", start, err == nil)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Println("Read total balance failed, err:", err)
+			zlog.ErrorF("Read total balance failed, err: %s", err)
+		}
+		return err
+	}
+	atomic.AddUint64(&stat.reads, 1)
+	if count != uint64(r.cfg.Accounts) || total != expected {
+		atomic.AddUint64(&stat.violations, 1)
+		r.addFailure(failureBalance)
+		now := time.Now().Format("2006-01-02 15:04:05.000000")
+		fmt.Printf("Bank check failed: [%s] total balance of %d accounts is %d, expect %d of %d accounts\n",
+			now, count, total, expected, r.cfg.Accounts)
+		zlog.ErrorF("Bank check failed: [%s] total balance of %d accounts is %d, expect %d of %d accounts",
+			now, count, total, expected, r.cfg.Accounts)
+		return ErrBalanceViolated
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package donkey
+
+import (
+	"context"
+	"donkey/pkg/config"
+	"errors"
+	"testing"
+)
+
+func TestRunner_Bank(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t.TempDir())
+	cfg.Workload = config.WorkloadBank
+	cfg.Accounts = 20
+	cfg.InsertRows = 500
+	r := newRunner(t, cfg)
+	if err := r.Prepare(ctx); err != nil {
+		t.Fatal("Prepare failed, err:", err)
+	}
+	if err := r.Bank(ctx); err != nil {
+		t.Fatal("Bank failed, err:", err)
+	}
+
+	expected := int64(cfg.Accounts) * initialBalance
+	stat := &bankStat{}
+	if err := r.checkBalance(ctx, expected, stat); err != nil {
+		t.Error("Total balance should be constant, err:", err)
+	}
+	changed := 0
+	err := r.dbs[0].QueryRow("SELECT COUNT(*) FROM donkey_accounts WHERE balance != ?", initialBalance).Scan(&changed)
+	if err != nil {
+		t.Fatal("Count changed accounts failed, err:", err)
+	}
+	if changed == 0 {
+		t.Error("Transfers should change balance of accounts")
+	}
+
+	// Half of a transfer.
+	if _, err = r.dbs[0].Exec("UPDATE donkey_accounts SET balance = balance - 10 WHERE id = 3"); err != nil {
+		t.Fatal("Update balance failed, err:", err)
+	}
+	if err = r.checkBalance(ctx, expected, stat); !errors.Is(err, ErrBalanceViolated) {
+		t.Error("Changed total balance should be a violation, err:", err)
+	}
+	if stat.violations != 1 {
+		t.Errorf("There are %d violations, expect 1", stat.violations)
+	}
+
+	// Existing accounts table must have accounts of config.
+	r.Close()
+	cfg.Accounts = 21
+	r = newRunner(t, cfg)
+	if err = r.Bank(ctx); !errors.Is(err, ErrDifferentAccountNum) {
+		t.Error("Bank with different account number should fail, err:", err)
+	}
+}
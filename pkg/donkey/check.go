@@ -0,0 +1,424 @@
+package donkey
+
+import (
+	"context"
+	"database/sql"
+	"donkey/pkg/archive"
+	"donkey/pkg/report"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+// Check checks every archived entry is in testing database with the same uuid,
+// checks rows of runs without archive by seed, and classifies every indeterminate entry. With reverse check, it also checks
+// every row of testing table is in archives.
+// It returns ctx error if ctx is canceled before all archives are checked, and ErrCheckFailed if any check fails.
+func (r *Runner) Check(ctx context.Context) (err error) {
+	phase := r.beginPhase("check")
+	defer r.endPhase(phase, &err)
+	fmt.Println("Checking...")
+	cfg := r.cfg
+	err = r.connectTestingDb()
+	if err != nil {
+		return err
+	}
+	stopReport := r.startReport("check")
+	defer stopReport()
+	r.metrics.routines.Set(int64(cfg.RoutineNum))
+	defer r.metrics.routines.Set(0)
+	failed := int32(0)
+	totalRows := uint64(0)
+	nowRow := uint64(0)
+	manifest, err := r.readManifest(false)
+	if err != nil {
+		if errors.Is(err, ErrDifferentRoutineNum) || errors.Is(err, ErrManifestMismatch) {
+			fmt.Println("Panic: Use different config of two tasks. err:", err)
+			return err
+		} else {
+			fmt.Println("Can't get total entry number, will not print progress rate.")
+		}
+	} else {
+		totalRows = manifest.TotalEntries()
+		r.metrics.checkTotal.Set(int64(totalRows))
+		err = r.validateManifest(manifest)
+		if err != nil {
+			failed = 1
+			r.addFailure(failureArchive)
+			fmt.Println("Check failed: archives don't have all entries of manifest, err:", err)
+			zlog.ErrorF("Check failed: archives don't have all entries of manifest, err: %s", err)
+		}
+	}
+	tenPercentRowNum := totalRows / 10
+	for _, a := range r.archives {
+		a.SeekForRead()
+	}
+
+	batch := int(cfg.CheckBatch)
+	if batch == 0 {
+		batch = 1
+	}
+
+	stat := mutationStat{}
+	wg := sync.WaitGroup{}
+	wg.Add(int(cfg.RoutineNum))
+	for i := 0; i < int(cfg.RoutineNum); i++ {
+		go func(routineId int) {
+			defer wg.Done()
+			pending, err := r.readPending(routineId)
+			if err != nil {
+				atomic.StoreInt32(&failed, 1)
+				r.addFailure(failureArchive)
+				fmt.Printf("Read archive failed: "+
+					"Check routine [%d] read indeterminate mutations failed, err: %s\n", routineId, err)
+				zlog.ErrorF("Read archive failed: "+
+					"Check routine [%d] read indeterminate mutations failed, err: %s", routineId, err)
+				return
+			}
+			// Rows are checked when they leave window, so mutated rows are checked by their final state.
+			window := newRowWindow()
+			for ctx.Err() == nil {
+				entries, readErr := readEntries(r.archives[routineId], cfg.ExtraColumnNum, batch)
+				localNowRow := atomic.AddUint64(&nowRow, uint64(len(entries)))
+				r.metrics.checkedEntries.Add(uint64(len(entries)))
+				if tenPercentRowNum != 0 &&
+					localNowRow/tenPercentRowNum != (localNowRow-uint64(len(entries)))/tenPercentRowNum {
+					fmt.Printf("Check progress: %d%% - (%d/%d)\n",
+						localNowRow/tenPercentRowNum*10, localNowRow, totalRows)
+				}
+				final := make([]*archive.Entry, 0, len(entries))
+				for _, entry := range entries {
+					if entry.Op == archive.OpInsert {
+						if oldest := window.push(entry); oldest != nil {
+							final = append(final, oldest)
+						}
+						continue
+					}
+					if !window.update(entry) {
+						// Routine mutates rows only in its window, it's a fault of client side.
+						atomic.StoreInt32(&failed, 1)
+						r.addFailure(failureArchive)
+						fmt.Printf("Archive corrupted: Check routine [%d] archive has %s of id %d out of window\n",
+							routineId, entry.Op, entry.Id)
+						zlog.ErrorF("Archive corrupted: Check routine [%d] archive has %s of id [%d] "+
+							"version [%d] out of window", routineId, entry.Op, entry.Id, entry.Version)
+					}
+				}
+				if readErr != nil {
+					final = append(final, window.drain()...)
+				}
+				failedIds := r.checkFinalRows(ctx, routineId, final, pending, batch, &stat)
+				if len(failedIds) != 0 {
+					atomic.StoreInt32(&failed, 1)
+					r.addFailedIds(failureData, failedIds...)
+				}
+				if readErr != nil {
+					if errors.Is(readErr, archive.ErrArchiveCorrupted) {
+						// It's a fault of client side, not a data loss of testing database.
+						atomic.StoreInt32(&failed, 1)
+						r.addFailure(failureArchive)
+						fmt.Printf("Archive corrupted: "+
+							"Check routine [%d] can't trust its archive file, err: %s\n", routineId, readErr)
+						zlog.ErrorF("Archive corrupted: "+
+							"Check routine [%d] can't trust its archive file, err: %s", routineId, readErr)
+					} else if !errors.Is(readErr, archive.ErrReadEndOfFile) {
+						atomic.StoreInt32(&failed, 1)
+						r.addFailure(failureArchive)
+						fmt.Printf("Read archive failed: "+
+							"Check routine [%d] read archive file failed, err: %s\n", routineId, readErr)
+						zlog.ErrorF("Read archive failed: "+
+							"Check routine [%d] read archive file failed, err: %s", routineId, readErr)
+					}
+					break
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if stat.updated+stat.deleted+stat.indeterminate != 0 {
+		fmt.Printf("Mutated rows: updated %d, deleted %d, indeterminate committed %d\n",
+			stat.updated, stat.deleted, stat.indeterminate)
+		zlog.InfoF("Mutated rows: updated %d, deleted %d, indeterminate committed %d",
+			stat.updated, stat.deleted, stat.indeterminate)
+	}
+	var unarchived idRanges
+	if ctx.Err() == nil && manifest != nil {
+		unarchivedFailed, err := r.checkUnarchivedRuns(ctx, manifest, batch)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if unarchivedFailed {
+			atomic.StoreInt32(&failed, 1)
+		}
+		unarchived = unarchivedRanges(manifest)
+	}
+	if ctx.Err() == nil {
+		indeterminateFailed, err := r.checkIndeterminate(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if indeterminateFailed {
+			atomic.StoreInt32(&failed, 1)
+		}
+	}
+	if ctx.Err() == nil && cfg.ReverseCheck {
+		reverseFailed, err := r.reverseCheck(ctx, unarchived)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if reverseFailed {
+			atomic.StoreInt32(&failed, 1)
+		}
+	}
+
+	stopReport()
+	phase.Counts["checked"] = atomic.LoadUint64(&nowRow)
+	phase.Counts["updated"] = stat.updated
+	phase.Counts["deleted"] = stat.deleted
+	fmt.Println()
+	if ctx.Err() != nil {
+		fmt.Println("Check canceled.")
+		return ctx.Err()
+	}
+	if atomic.LoadInt32(&failed) != 0 {
+		fmt.Println("Check failed...")
+		return ErrCheckFailed
+	}
+	fmt.Println("Check success!")
+	return nil
+}
+
+// readEntries reads at most n entries from archive.
+// Entries read before error are returned with the error.
+func readEntries(a *archive.Archive, extraNum uint, n int) ([]*archive.Entry, error) {
+	entries := make([]*archive.Entry, 0, n)
+	for len(entries) < n {
+		entry, err := a.GetOneEntry(extraNum)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// checkEntriesOneByOne checks entries with one point lookup for each entry.
+// It returns ids of failed entries.
+func (r *Runner) checkEntriesOneByOne(ctx context.Context, routineId int, entries []*archive.Entry) []uint64 {
+	var failedIds []uint64
+	for _, entry := range entries {
+		row, err := r.lookupRow(ctx, routineId, entry.Id)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			r.reportLookupFailed(routineId, entry, err)
+			failedIds = append(failedIds, entry.Id)
+			continue
+		}
+		if !r.sameAsRow(entry, row) {
+			failedIds = append(failedIds, entry.Id)
+		}
+	}
+	return failedIds
+}
+
+// checkEntriesInBatch checks entries with one lookup for all of them.
+// Failures are the same as checking one by one.
+func (r *Runner) checkEntriesInBatch(ctx context.Context, routineId int, entries []*archive.Entry) []uint64 {
+	if len(entries) == 0 {
+		return nil
+	}
+	var failedIds []uint64
+	ids := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.Id)
+	}
+	rows, err := r.lookupRows(ctx, routineId, ids)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		for _, entry := range entries {
+			r.reportLookupFailed(routineId, entry, err)
+		}
+		return ids
+	}
+	for _, entry := range entries {
+		row, ok := rows[entry.Id]
+		if !ok {
+			r.reportLookupFailed(routineId, entry, sql.ErrNoRows)
+			failedIds = append(failedIds, entry.Id)
+			continue
+		}
+		if !r.sameAsRow(entry, row) {
+			failedIds = append(failedIds, entry.Id)
+		}
+	}
+	return failedIds
+}
+
+func (r *Runner) reportLookupFailed(routineId int, entry *archive.Entry, err error) {
+	fmt.Printf("Check failed: Select id %d failed, err: %s\n", entry.Id, err)
+	zlog.ErrorF("Check failed: Select routine [%d] id [%d] & uuid [%s] failed, err: %s",
+		routineId, entry.Id, entry.Uuid, err)
+}
+
+// lookupRows selects rows of ids from testing table, rows are keyed by id.
+// Ids not in testing table are not in the result.
+func (r *Runner) lookupRows(ctx context.Context, routineId int, ids []uint64) (result map[uint64][][]byte, err error) {
+	start := time.Now()
+	defer func() {
+		r.record("batch-lookup", start, err == nil)
+	}()
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := r.dbs[routineId].QueryContext(ctx, r.op.BatchLookupSQL(len(ids)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	result = make(map[uint64][][]byte, len(ids))
+	for rows.Next() {
+		uuidVec := make([][]byte, r.cfg.ExtraColumnNum+2)
+		scanVec := make([]interface{}, r.cfg.ExtraColumnNum+2)
+		for i := range uuidVec {
+			scanVec[i] = &uuidVec[i]
+		}
+		if err = rows.Scan(scanVec...); err != nil {
+			return nil, err
+		}
+		id, err := strconv.ParseUint(string(uuidVec[0]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		result[id] = uuidVec
+	}
+	return result, rows.Err()
+}
+
+// lookupRow selects row of id from testing table.
+// Row is values of all columns, it returns sql.ErrNoRows if row isn't exist.
+func (r *Runner) lookupRow(ctx context.Context, routineId int, id uint64) ([][]byte, error) {
+	start := time.Now()
+	row := r.dbs[routineId].QueryRowContext(ctx, r.op.PointLookupSQL(), id)
+	uuidVec := make([][]byte, r.cfg.ExtraColumnNum+2)
+	scanVec := make([]interface{}, r.cfg.ExtraColumnNum+2)
+	for i := range uuidVec {
+		scanVec[i] = &uuidVec[i]
+	}
+	err := row.Scan(scanVec...)
+	// Absent row is a successful lookup.
+	r.record("lookup", start, err == nil || errors.Is(err, sql.ErrNoRows))
+	if err != nil {
+		return nil, err
+	}
+	return uuidVec, nil
+}
+
+// sameAsRow compares entry with row of testing table, and reports every different column.
+func (r *Runner) sameAsRow(entry *archive.Entry, row [][]byte) bool {
+	same := true
+	if entry.Uuid != string(row[1]) {
+		fmt.Printf("Check failed: id %d uuid different between archive & database\n.", entry.Id)
+		zlog.ErrorF("Check failed: id [%d] uuid different between archive & database."+
+			" Archive: [%s] Database: [%s]", entry.Id, entry.Uuid, string(row[1]))
+		same = false
+	}
+	for column := uint(0); column < r.cfg.ExtraColumnNum; column++ {
+		if entry.ExtraUuid[column] != string(row[column+2]) {
+			fmt.Printf("Check failed: id %d uuid different between archive & database\n.", entry.Id)
+			zlog.ErrorF("Check failed: id [%d] uuid different between archive & database."+
+				" Archive: [%s] Database: [%s]",
+				entry.Id, entry.ExtraUuid[column], string(row[column+2]))
+			same = false
+		}
+	}
+	return same
+}
+
+// sameValues reports whether entry and row have the same uuid in every column.
+func sameValues(entry *archive.Entry, row [][]byte) bool {
+	if entry.Uuid != string(row[1]) {
+		return false
+	}
+	for column := range entry.ExtraUuid {
+		if entry.ExtraUuid[column] != string(row[column+2]) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkIndeterminate classifies every indeterminate entry as committed (same values in database),
+// absent (not in database) or corrupted (different values in database).
+// It returns true if any entry is corrupted or can't be classified.
+func (r *Runner) checkIndeterminate(ctx context.Context) (bool, error) {
+	failed := false
+	committed, absent, corrupted := uint64(0), uint64(0), uint64(0)
+	for routineId, a := range r.indeterminates {
+		a.SeekForRead()
+		for ctx.Err() == nil {
+			entry, err := a.GetOneEntry(r.cfg.ExtraColumnNum)
+			if err != nil {
+				if errors.Is(err, archive.ErrReadEndOfFile) {
+					break
+				}
+				fmt.Printf("Read indeterminate archive of routine [%d] failed, err: %s\n", routineId, err)
+				zlog.ErrorF("Read indeterminate archive of routine [%d] failed, err: %s", routineId, err)
+				return true, err
+			}
+			if entry.Op != archive.OpInsert {
+				// Failed update or delete is checked with the latest state of row.
+				continue
+			}
+			row, err := r.lookupRow(ctx, routineId, entry.Id)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					absent++
+					zlog.InfoF("Indeterminate id [%d] is absent", entry.Id)
+					continue
+				}
+				if ctx.Err() != nil {
+					break
+				}
+				r.addFailedIds(failureIndeterminate, entry.Id)
+				fmt.Printf("Check failed: Select indeterminate id %d failed, err: %s\n", entry.Id, err)
+				zlog.ErrorF("Check failed: Select indeterminate id [%d] failed, err: %s", entry.Id, err)
+				failed = true
+				continue
+			}
+			if sameValues(entry, row) {
+				committed++
+				zlog.InfoF("Indeterminate id [%d] is committed", entry.Id)
+			} else {
+				corrupted++
+				failed = true
+				r.addFailedIds(failureIndeterminate, entry.Id)
+				fmt.Printf("Check failed: indeterminate id %d is corrupted\n", entry.Id)
+				zlog.ErrorF("Check failed: indeterminate id [%d] is corrupted, it's different from "+
+					"the value tried to insert. Archive: %v Database: %s",
+					entry.Id, append([]string{entry.Uuid}, entry.ExtraUuid...), row[1:])
+			}
+		}
+	}
+	r.result.mu.Lock()
+	r.result.indeterminate = report.Indeterminate{Committed: committed, Absent: absent, Corrupted: corrupted}
+	r.result.mu.Unlock()
+	if committed+absent+corrupted != 0 {
+		fmt.Printf("Indeterminate entries: committed %d, absent %d, corrupted %d\n",
+			committed, absent, corrupted)
+		zlog.InfoF("Indeterminate entries: committed %d, absent %d, corrupted %d",
+			committed, absent, corrupted)
+	}
+	return failed, ctx.Err()
+}
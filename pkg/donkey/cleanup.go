@@ -0,0 +1,79 @@
+package donkey
+
+import (
+	"donkey/pkg/config"
+	"donkey/pkg/operator"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Cleanup drops testing table and accounts table, or the whole testing database if dropDatabase is true.
+// Then it removes archives and manifest in work dir, result logs are kept.
+// Archives are useless without testing data, don't run it while another donkey is running.
+func Cleanup(cfg *config.Config, dropDatabase bool) error {
+	op, err := operator.GetOperator(cfg.DbType)
+	if err != nil {
+		fmt.Println("Unknown database type:", cfg.DbType)
+		return err
+	}
+	r := &Runner{cfg: cfg, op: op}
+	if dropDatabase {
+		err = r.openDbs("")
+		if err == nil {
+			err = op.DropDatabase(r.dbs[0], cfg)
+		}
+	} else {
+		err = r.dropTables()
+	}
+	r.closeDbs()
+	if err != nil {
+		fmt.Println("Drop testing data failed, err:", err)
+		return err
+	}
+	return removeLocalFiles(cfg.WorkDir)
+}
+
+func (r *Runner) dropTables() error {
+	err := r.connectTestingDb()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{operator.TestingTable, operator.AccountsTable} {
+		_, err = r.dbs[0].Exec(r.op.DropTableSQL(table))
+		if err != nil {
+			fmt.Printf("Drop table %s failed, err: %s\n", table, err)
+			return err
+		}
+		fmt.Printf("Table %s is dropped\n", table)
+	}
+	return nil
+}
+
+// removeLocalFiles removes archives, manifest and entry number file of old versions in dir.
+func removeLocalFiles(dir string) error {
+	files, err := archiveFiles(dir)
+	if err != nil {
+		fmt.Println("List archive files failed, err:", err)
+		return err
+	}
+	for _, name := range []string{manifestFileName, manifestFileName + ".tmp", legacyEntryNumFileName} {
+		files = append(files, filepath.Join(dir, name))
+	}
+	removed := 0
+	for _, file := range files {
+		err = os.Remove(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Remove %s failed, err: %s\n", file, err)
+			return err
+		}
+		removed++
+	}
+	fmt.Printf("Removed %d files in %s\n", removed, dir)
+	return nil
+}
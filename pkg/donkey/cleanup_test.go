@@ -0,0 +1,96 @@
+package donkey
+
+import (
+	"bytes"
+	"donkey/pkg/archive"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	runDonkey(t, cfg)
+	out := &bytes.Buffer{}
+	if err := Inspect(cfg, out, InspectOptions{}); err != nil {
+		t.Fatal("Inspect failed, err:", err)
+	}
+	if !strings.Contains(out.String(), "1000 archived entries") ||
+		strings.Count(out.String(), "Archive "+archive.FilePrefix) != int(cfg.RoutineNum) {
+		t.Errorf("Unexpected inspect output:\n%s", out)
+	}
+
+	out.Reset()
+	if err := Inspect(cfg, out, InspectOptions{Format: archive.FormatCSV}); err != nil {
+		t.Fatal("Dump failed, err:", err)
+	}
+	// A header row, and a row for every entry.
+	if lines := strings.Count(out.String(), "\n"); lines != int(cfg.InsertRows)+1 {
+		t.Errorf("Dumped %d lines, expect %d", lines, cfg.InsertRows+1)
+	}
+
+	out.Reset()
+	if err := Inspect(cfg, out, InspectOptions{Format: archive.FormatJSON, Ids: []uint64{42, 5000}}); err != nil {
+		t.Fatal("Look up failed, err:", err)
+	}
+	entry := struct {
+		Id        uint64   `json:"id"`
+		Op        string   `json:"op"`
+		ExtraUuid []string `json:"extra-uuid"`
+	}{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Decode looked up entry %q failed, err: %s", out, err)
+	}
+	if entry.Id != 42 || entry.Op != "insert" || len(entry.ExtraUuid) != int(cfg.ExtraColumnNum) {
+		t.Errorf("Unexpected looked up entry %+v", entry)
+	}
+
+	// Validation stops at the corrupted record.
+	fileName := filepath.Join(cfg.WorkDir, archive.FilePrefix+"0")
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal("Read archive failed, err:", err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err = os.WriteFile(fileName, data, 0666); err != nil {
+		t.Fatal("Write archive failed, err:", err)
+	}
+	out.Reset()
+	if err = Inspect(cfg, out, InspectOptions{}); !errors.Is(err, archive.ErrArchiveCorrupted) {
+		t.Error("Inspect should find corrupted archive, err:", err)
+	}
+	if !strings.Contains(out.String(), "Corrupted:") {
+		t.Errorf("Corrupted record should be printed:\n%s", out)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	r := runDonkey(t, cfg)
+	r.Close()
+	if err := Cleanup(cfg, false); err != nil {
+		t.Fatal("Cleanup failed, err:", err)
+	}
+	for _, name := range []string{archive.FilePrefix + "0", archive.IndeterminateFilePrefix + "0", manifestFileName} {
+		if _, err := os.Stat(filepath.Join(cfg.WorkDir, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s should be removed, err: %v", name, err)
+		}
+	}
+	// Testing table is dropped, so a new run starts from empty table.
+	r = runDonkey(t, cfg)
+	if count := countRows(t, r); count != cfg.InsertRows {
+		t.Errorf("Table has %d rows after cleanup and run, expect %d", count, cfg.InsertRows)
+	}
+	r.Close()
+
+	if err := Cleanup(cfg, true); err != nil {
+		t.Fatal("Cleanup database failed, err:", err)
+	}
+	if _, err := os.Stat(cfg.Database); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Database file should be removed, err:", err)
+	}
+}
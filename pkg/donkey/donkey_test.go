@@ -0,0 +1,568 @@
+package donkey
+
+import (
+	"context"
+	"database/sql"
+	"donkey/pkg/archive"
+	"donkey/pkg/config"
+	"donkey/pkg/operator"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+// TestMain writes log of tests to a temp dir, otherwise zlog creates its file in package dir.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "donkey_log")
+	if err != nil {
+		fmt.Println("Create log dir failed, err:", err)
+		os.Exit(1)
+	}
+	err = zlog.New(dir, "donkey_test", false, zlog.LogLevelAll)
+	if err != nil {
+		fmt.Println("Create logger failed, err:", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func sqliteConfig(dir string) *config.Config {
+	return &config.Config{
+		DbType:         "sqlite",
+		Database:       filepath.Join(dir, "donkey.db"),
+		InsertRows:     1000,
+		RoutineNum:     4,
+		InsertData:     true,
+		CheckData:      true,
+		ArchiveData:    true,
+		InsertPackage:  10,
+		ExtraColumnNum: 2,
+		WorkDir:        dir,
+	}
+}
+
+func newRunner(t *testing.T, cfg *config.Config) *Runner {
+	r, err := NewRunner(cfg)
+	if err != nil {
+		t.Fatal("New runner failed, err:", err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+func runDonkey(t *testing.T, cfg *config.Config) *Runner {
+	ctx := context.Background()
+	r := newRunner(t, cfg)
+	if err := r.Prepare(ctx); err != nil {
+		t.Fatal("Prepare failed, err:", err)
+	}
+	if err := r.Insert(ctx); err != nil {
+		t.Fatal("Insert failed, err:", err)
+	}
+	if err := r.Check(ctx); err != nil {
+		t.Fatal("Check failed, err:", err)
+	}
+	return r
+}
+
+func countRows(t *testing.T, r *Runner) uint64 {
+	count := uint64(0)
+	err := r.dbs[0].QueryRow("SELECT COUNT(*) FROM donkey_test").Scan(&count)
+	if err != nil {
+		t.Fatal("Count testing table failed, err:", err)
+	}
+	return count
+}
+
+func TestRunner_SQLite(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	r := runDonkey(t, cfg)
+
+	if count := countRows(t, r); count != cfg.InsertRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows)
+	}
+	manifest, err := r.readManifest(false)
+	if err != nil {
+		t.Fatal("Read manifest failed, err:", err)
+	}
+	if total := manifest.TotalEntries(); total != cfg.InsertRows {
+		t.Errorf("Archives have %d entries, expect %d", total, cfg.InsertRows)
+	}
+}
+
+func TestRunner_SQLitePath(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.Database = filepath.Join(cfg.WorkDir, "donkey #1?mode=ro%20.db")
+	r := runDonkey(t, cfg)
+
+	if count := countRows(t, r); count != cfg.InsertRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows)
+	}
+	if _, err := os.Stat(cfg.Database); err != nil {
+		t.Error("Database file should be created with its path, err:", err)
+	}
+}
+
+func TestRunner_SQLiteResume(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	runDonkey(t, cfg).Close()
+	r := runDonkey(t, cfg)
+
+	if count := countRows(t, r); count != 2*cfg.InsertRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, 2*cfg.InsertRows)
+	}
+	maxId := uint64(0)
+	if err := r.dbs[0].QueryRow(r.op.MaxIdSQL()).Scan(&maxId); err != nil {
+		t.Fatal("Get max id failed, err:", err)
+	}
+	if maxId != 2*cfg.InsertRows-1 {
+		t.Errorf("Max id is %d, expect %d", maxId, 2*cfg.InsertRows-1)
+	}
+}
+
+func TestRunner_PostSQL(t *testing.T) {
+	dir := t.TempDir()
+	cfg := sqliteConfig(dir)
+	cfg.PostSQL = filepath.Join(dir, "post.sql")
+	err := os.WriteFile(cfg.PostSQL, []byte("CREATE TABLE donkey_post (id INTEGER)\nINSERT INTO donkey_post VALUES (1)\n"), 0666)
+	if err != nil {
+		t.Fatal("Write post SQL failed, err:", err)
+	}
+	r := runDonkey(t, cfg)
+	if err = r.Finish(context.Background()); err != nil {
+		t.Fatal("Finish failed, err:", err)
+	}
+	// Statements of file are committed, so another connection sees them.
+	count := 0
+	if err = r.dbs[0].QueryRow("SELECT COUNT(*) FROM donkey_post").Scan(&count); err != nil || count != 1 {
+		t.Errorf("Post SQL table has %d rows, expect 1, err: %v", count, err)
+	}
+}
+
+func TestRunner_Parallel(t *testing.T) {
+	cfgs := []*config.Config{sqliteConfig(t.TempDir()), sqliteConfig(t.TempDir())}
+	cfgs[1].InsertRows = 500
+	runners := make([]*Runner, len(cfgs))
+	wg := sync.WaitGroup{}
+	for i := range cfgs {
+		runners[i] = newRunner(t, cfgs[i])
+		if err := runners[i].Prepare(context.Background()); err != nil {
+			t.Fatal("Prepare failed, err:", err)
+		}
+	}
+	for i := range runners {
+		wg.Add(1)
+		go func(r *Runner) {
+			defer wg.Done()
+			if err := r.Insert(context.Background()); err != nil {
+				t.Error("Insert failed, err:", err)
+			}
+		}(runners[i])
+	}
+	wg.Wait()
+	for i, r := range runners {
+		if count := countRows(t, r); count != cfgs[i].InsertRows {
+			t.Errorf("Runner %d testing table has %d rows, expect %d", i, count, cfgs[i].InsertRows)
+		}
+	}
+}
+
+func TestRunner_Cancel(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertRows = 0
+	r := newRunner(t, cfg)
+	if err := r.Prepare(context.Background()); err != nil {
+		t.Fatal("Prepare failed, err:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Insert(ctx); err != nil {
+		t.Fatal("Canceled insert failed, err:", err)
+	}
+	if err := r.Check(ctx); err != context.Canceled {
+		t.Errorf("Canceled check returns %v, expect %v", err, context.Canceled)
+	}
+}
+
+func TestRunner_Indeterminate(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t.TempDir())
+	r := newRunner(t, cfg)
+	if err := r.Prepare(ctx); err != nil {
+		t.Fatal("Prepare failed, err:", err)
+	}
+	// The last insert (id 990 - 999) fails.
+	_, err := r.dbs[0].Exec("CREATE TRIGGER donkey_fail BEFORE INSERT ON donkey_test " +
+		"WHEN NEW.id = 995 BEGIN SELECT RAISE(ABORT, 'injected failure'); END")
+	if err != nil {
+		t.Fatal("Create trigger failed, err:", err)
+	}
+	if err = r.Insert(ctx); err != nil {
+		t.Fatal("Insert failed, err:", err)
+	}
+	failedRows := uint64(cfg.InsertPackage)
+	if count := countRows(t, r); count != cfg.InsertRows-failedRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows-failedRows)
+	}
+	failed, err := r.checkIndeterminate(ctx)
+	if err != nil || failed {
+		t.Errorf("Absent indeterminate entries should pass check, failed: %v, err: %v", failed, err)
+	}
+
+	// Resume never reuses indeterminate ids.
+	if err = r.Insert(ctx); err != nil {
+		t.Fatal("Insert failed, err:", err)
+	}
+	if count := countRows(t, r); count != 2*cfg.InsertRows-failedRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, 2*cfg.InsertRows-failedRows)
+	}
+	if _, err = r.lookupRow(ctx, 0, 990); err != sql.ErrNoRows {
+		t.Errorf("Indeterminate id 990 is reused, err: %v", err)
+	}
+
+	// A phantom row of indeterminate id is corrupted.
+	_, err = r.dbs[0].Exec("DROP TRIGGER donkey_fail")
+	if err != nil {
+		t.Fatal("Drop trigger failed, err:", err)
+	}
+	_, err = r.dbs[0].Exec("INSERT INTO donkey_test VALUES (995, 'phantom', 'phantom', 'phantom')")
+	if err != nil {
+		t.Fatal("Insert phantom row failed, err:", err)
+	}
+	failed, err = r.checkIndeterminate(ctx)
+	if err != nil || !failed {
+		t.Errorf("Corrupted indeterminate entry should fail check, failed: %v, err: %v", failed, err)
+	}
+}
+
+func TestRunner_ReverseCheck(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertRows = 2500
+	cfg.ReverseCheck = true
+	r := runDonkey(t, cfg)
+	failed, err := r.reverseCheck(ctx, nil)
+	if err != nil || failed {
+		t.Errorf("All rows are in archives, failed: %v, err: %v", failed, err)
+	}
+
+	// A lost row is reported by forward check, reverse check skips it.
+	if _, err = r.dbs[0].Exec("DELETE FROM donkey_test WHERE id = 1500"); err != nil {
+		t.Fatal("Delete row failed, err:", err)
+	}
+	failed, err = r.reverseCheck(ctx, nil)
+	if err != nil || failed {
+		t.Errorf("Lost row should pass reverse check, failed: %v, err: %v", failed, err)
+	}
+	// Rows not written by donkey.
+	for _, id := range []uint64{2500, 99999} {
+		_, err = r.dbs[0].Exec("INSERT INTO donkey_test VALUES (?, 'extra', 'extra', 'extra')", id)
+		if err != nil {
+			t.Fatal("Insert extra row failed, err:", err)
+		}
+	}
+	failed, err = r.reverseCheck(ctx, nil)
+	if err != nil || !failed {
+		t.Errorf("Extra rows should fail reverse check, failed: %v, err: %v", failed, err)
+	}
+}
+
+func TestRunner_CheckBatch(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t.TempDir())
+	cfg.CheckBatch = 64
+	r := runDonkey(t, cfg)
+	if _, err := r.dbs[0].Exec("DELETE FROM donkey_test WHERE id IN (10, 500)"); err != nil {
+		t.Fatal("Delete rows failed, err:", err)
+	}
+	if _, err := r.dbs[0].Exec("UPDATE donkey_test SET uuid_extra_1 = 'bad' WHERE id IN (11, 999)"); err != nil {
+		t.Fatal("Update rows failed, err:", err)
+	}
+	failedNum := 0
+	for routineId, a := range r.archives {
+		a.SeekForRead()
+		entries, err := readEntries(a, cfg.ExtraColumnNum, int(cfg.InsertRows))
+		if err != archive.ErrReadEndOfFile {
+			t.Fatal("Read archive failed, err:", err)
+		}
+		oneByOne := r.checkEntriesOneByOne(ctx, routineId, entries)
+		inBatch := r.checkEntriesInBatch(ctx, routineId, entries)
+		if !reflect.DeepEqual(oneByOne, inBatch) {
+			t.Errorf("Routine %d failed ids are different, one by one: %v, in batch: %v",
+				routineId, oneByOne, inBatch)
+		}
+		failedNum += len(inBatch)
+	}
+	if failedNum != 4 {
+		t.Errorf("%d ids failed, expect 4", failedNum)
+	}
+}
+
+func TestRunner_TornArchive(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t.TempDir())
+	runDonkey(t, cfg).Close()
+
+	// Killed in the middle of appending a record.
+	f, err := os.OpenFile(filepath.Join(cfg.WorkDir, "donkey_archive_0"), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal("Open archive failed, err:", err)
+	}
+	if _, err = f.Write([]byte{100, 7, 'a', 'b'}); err != nil {
+		t.Fatal("Write torn record failed, err:", err)
+	}
+	_ = f.Close()
+
+	r := runDonkey(t, cfg)
+	if r.archives[0].DroppedBytes() != 4 {
+		t.Errorf("Dropped %d bytes, expect 4", r.archives[0].DroppedBytes())
+	}
+	for _, a := range r.archives {
+		a.SeekForRead()
+		_, err = readEntries(a, cfg.ExtraColumnNum, int(2*cfg.InsertRows)+1)
+		if !errors.Is(err, archive.ErrReadEndOfFile) {
+			t.Errorf("Archive of routine [%d] should be read to the end, err: %v", a.Id, err)
+		}
+	}
+	failed, err := r.reverseCheck(ctx, nil)
+	if err != nil || failed {
+		t.Errorf("All rows are in archives, failed: %v, err: %v", failed, err)
+	}
+}
+
+func TestRunner_Mixed(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertRows = 5000
+	cfg.RoutineNum = 2
+	cfg.UpdatePercent = 30
+	cfg.DeletePercent = 20
+	cfg.ReverseCheck = true
+	r := runDonkey(t, cfg)
+
+	// The latest entry of every mutated row.
+	latest := make(map[uint64]*archive.Entry)
+	for _, a := range r.archives {
+		a.SeekForRead()
+		entries, err := readEntries(a, cfg.ExtraColumnNum, int(2*cfg.InsertRows))
+		if !errors.Is(err, archive.ErrReadEndOfFile) {
+			t.Fatal("Read archive failed, err:", err)
+		}
+		for _, entry := range entries {
+			if last, ok := latest[entry.Id]; entry.Op != archive.OpInsert && (!ok || entry.Version > last.Version) {
+				latest[entry.Id] = entry
+			}
+		}
+	}
+	var updatedId, deletedId uint64
+	updated, deleted := uint64(0), uint64(0)
+	for id, entry := range latest {
+		if entry.Op == archive.OpDelete {
+			deleted++
+			deletedId = id
+		} else {
+			updated++
+			updatedId = id
+		}
+	}
+	if updated == 0 || deleted == 0 {
+		t.Fatal("Mixed workload should update and delete rows")
+	}
+	if count := countRows(t, r); count != cfg.InsertRows-deleted {
+		t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows-deleted)
+	}
+	phases := r.Report(nil).Phases
+	phase := phases[len(phases)-1]
+	if phase.Counts["updated"] != updated || phase.Counts["deleted"] != deleted {
+		t.Errorf("Check verifies %d updated and %d deleted rows, expect %d and %d",
+			phase.Counts["updated"], phase.Counts["deleted"], updated, deleted)
+	}
+
+	// Lost update and un-deleted row.
+	if _, err := r.dbs[0].Exec("UPDATE donkey_test SET uuid = 'stale' WHERE id = ?", updatedId); err != nil {
+		t.Fatal("Update row failed, err:", err)
+	}
+	_, err := r.dbs[0].Exec("INSERT INTO donkey_test VALUES (?, 'back', 'back', 'back')", deletedId)
+	if err != nil {
+		t.Fatal("Insert deleted row failed, err:", err)
+	}
+	r = newRunner(t, cfg)
+	if err = r.Check(ctx); !errors.Is(err, ErrCheckFailed) {
+		t.Fatal("Check should fail, err:", err)
+	}
+	failedIds := r.failure(failureData).Ids
+	sort.Slice(failedIds, func(i, j int) bool { return failedIds[i] < failedIds[j] })
+	expect := []uint64{updatedId, deletedId}
+	sort.Slice(expect, func(i, j int) bool { return expect[i] < expect[j] })
+	if !reflect.DeepEqual(failedIds, expect) {
+		t.Errorf("Failed ids are %v, expect %v", failedIds, expect)
+	}
+}
+
+func TestRowWindow(t *testing.T) {
+	live := newLiveRows()
+	window := newRowWindow()
+	var final []*archive.Entry
+	for id := uint64(0); id < mutationWindow+10; id++ {
+		entry := &archive.Entry{Id: id}
+		live.add([]*archive.Entry{entry})
+		if oldest := window.push(entry); oldest != nil {
+			final = append(final, oldest)
+		}
+	}
+	// Rows leave both windows in insert order.
+	if len(live.ids) != mutationWindow || len(final) != 10 || final[0].Id != 0 || final[9].Id != 9 {
+		t.Errorf("Window has %d live rows and %d final rows", len(live.ids), len(final))
+	}
+	for _, id := range []uint64{9, 10} {
+		if _, ok := live.versions[id]; ok != (id == 10) {
+			t.Errorf("Row %d in live rows is %v", id, ok)
+		}
+	}
+	live.remove(live.index[10])
+	if _, ok := live.index[10]; ok || len(live.ids) != mutationWindow-1 || live.ids[live.index[11]] != 11 {
+		t.Error("Removed row should leave live rows")
+	}
+	if window.update(&archive.Entry{Id: 9, Op: archive.OpUpdate, Version: 1}) {
+		t.Error("Row out of window should not be updated")
+	}
+	if !window.update(&archive.Entry{Id: 10, Op: archive.OpDelete, Version: 2}) {
+		t.Error("Row in window should be updated")
+	}
+	window.update(&archive.Entry{Id: 10, Op: archive.OpUpdate, Version: 1})
+	if window.latest[10].Version != 2 {
+		t.Errorf("Row in window is in version %d, expect the latest 2", window.latest[10].Version)
+	}
+	rest := window.drain()
+	if len(rest) != mutationWindow || rest[0].Id != 10 || rest[0].Op != archive.OpDelete || len(window.latest) != 0 {
+		t.Errorf("Drain returns %d rows, the first is %+v", len(rest), rest[0])
+	}
+}
+
+func TestRunner_Transaction(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertRows = 900
+	cfg.TxStatements = 3
+	cfg.Isolation = "serializable"
+	cfg.ReverseCheck = true
+	r := newRunner(t, cfg)
+	if err := r.Prepare(ctx); err != nil {
+		t.Fatal("Prepare failed, err:", err)
+	}
+	// Transaction of ids 600 - 629 is rolled back.
+	_, err := r.dbs[0].Exec("CREATE TRIGGER donkey_fail BEFORE INSERT ON donkey_test " +
+		"WHEN NEW.id = 615 BEGIN SELECT RAISE(ABORT, 'injected failure'); END")
+	if err != nil {
+		t.Fatal("Create trigger failed, err:", err)
+	}
+	if err = r.Insert(ctx); err != nil {
+		t.Fatal("Insert failed, err:", err)
+	}
+	failedRows := uint64(cfg.InsertPackage) * uint64(cfg.TxStatements)
+	if count := countRows(t, r); count != cfg.InsertRows-failedRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows-failedRows)
+	}
+	for _, id := range []uint64{600, 629} {
+		if _, err = r.lookupRow(ctx, 0, id); err != sql.ErrNoRows {
+			t.Errorf("Id %d of rolled back transaction is in testing table, err: %v", id, err)
+		}
+	}
+	// Rolled back entries are neither archived nor indeterminate.
+	if n := manifestEntries(t, r); n != cfg.InsertRows-failedRows {
+		t.Errorf("Archives have %d entries, expect %d", n, cfg.InsertRows-failedRows)
+	}
+	for _, a := range r.indeterminates {
+		if a.EntryCount() != 0 {
+			t.Errorf("Indeterminate archive of routine [%d] has %d entries", a.Id, a.EntryCount())
+		}
+	}
+	failed, err := r.reverseCheck(ctx, nil)
+	if err != nil || failed {
+		t.Errorf("All rows are in archives, failed: %v, err: %v", failed, err)
+	}
+
+	// SQLite can't begin transaction of other levels.
+	cfg.Isolation = "read-committed"
+	entries := []*archive.Entry{{Id: 10000, Uuid: "a", ExtraUuid: []string{"b", "c"}}}
+	if result := r.insertEntries(0, entries, cfg.InsertPackage); result != insertAbsent {
+		t.Errorf("Insert with unsupported isolation returns %d, expect absent", result)
+	}
+}
+
+func manifestEntries(t *testing.T, r *Runner) uint64 {
+	m, err := r.readManifest(false)
+	if err != nil {
+		t.Fatal("Read manifest failed, err:", err)
+	}
+	return m.TotalEntries()
+}
+
+func TestRunner_Prepared(t *testing.T) {
+	for _, txStatements := range []uint{0, 3} {
+		cfg := sqliteConfig(t.TempDir())
+		cfg.InsertRows = 995
+		cfg.InsertMethod = config.InsertMethodPrepared
+		cfg.TxStatements = txStatements
+		r := runDonkey(t, cfg)
+		if count := countRows(t, r); count != cfg.InsertRows {
+			t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows)
+		}
+		failed, err := r.reverseCheck(context.Background(), nil)
+		if err != nil || failed {
+			t.Errorf("All rows are in archives, failed: %v, err: %v", failed, err)
+		}
+		if r.stmts != nil {
+			t.Error("Prepared statements should be closed after insert")
+		}
+	}
+}
+
+func TestRunner_BulkNotSupported(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertMethod = config.InsertMethodBulk
+	r := newRunner(t, cfg)
+	if err := r.Prepare(context.Background()); err != nil {
+		t.Fatal("Prepare failed, err:", err)
+	}
+	if err := r.Insert(context.Background()); !errors.Is(err, operator.ErrBulkLoadNotSupported) {
+		t.Error("SQLite should not support bulk load, err:", err)
+	}
+}
+
+func TestRunner_Metrics(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.MetricsAddr = "127.0.0.1:0"
+	r := runDonkey(t, cfg)
+	if err := r.ServeMetrics(); err != nil {
+		t.Fatal("Serve metrics failed, err:", err)
+	}
+	resp, err := http.Get("http://" + r.metricsServer.Addr + "/metrics")
+	if err != nil {
+		t.Fatal("Get metrics failed, err:", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, line := range []string{
+		fmt.Sprintf("donkey_rows_inserted_total %d", cfg.InsertRows),
+		fmt.Sprintf("donkey_check_entries_total %d", cfg.InsertRows),
+		`donkey_operation_duration_seconds_count{op="lookup",result="ok"} 1000`,
+		"donkey_routines 0",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Metrics don't have line %q", line)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package donkey
+
+import (
+	"context"
+	"database/sql"
+	"donkey/pkg/archive"
+	"donkey/pkg/config"
+	"donkey/pkg/operator"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+// Insert inserts testing data until row limit or duration of config, the end of schedule,
+// or ctx is canceled. Canceling ctx isn't an error, inserted rows are recorded.
+func (r *Runner) Insert(ctx context.Context) (err error) {
+	phase := r.beginPhase("insert")
+	defer r.endPhase(phase, &err)
+	cfg := r.cfg
+	err = r.connectTestingDb()
+	if err != nil {
+		return err
+	}
+	if _, ok := r.op.(operator.BulkLoader); !ok && cfg.InsertMethod == config.InsertMethodBulk {
+		fmt.Printf("Database %s doesn't support bulk load\n", r.op.Name())
+		return operator.ErrBulkLoadNotSupported
+	}
+	// Get max id in testing table (if exist)
+	maxId := uint64(0)
+	err = r.dbs[0].QueryRow(r.op.MaxIdSQL()).Scan(&maxId)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			fmt.Println("Get max insert id from testing table failed, err:", err)
+		}
+		maxId = 0
+	} else {
+		maxId++
+	}
+	// Read manifest of last run
+	originManifest, err := r.readManifest(true)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			if maxId != 0 {
+				fmt.Println("Panic: Manifest is lost!")
+				return ErrManifestLost
+			}
+		} else {
+			fmt.Println("Read manifest failed, err:", err)
+			return err
+		}
+	} else {
+		if maxId == 0 && originManifest.TotalEntries()+originManifest.UnarchivedRows() != 0 {
+			fmt.Println("Panic: Testing database data is lost!")
+			return ErrDatabaseDataLost
+		}
+		err = r.validateManifest(originManifest)
+		if err != nil {
+			fmt.Println("Panic: Archive data is lost!")
+			return err
+		}
+		// Rows of runs without archive are checked with their seed, it can't be changed.
+		err = r.checkSeed(originManifest)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Mixed() {
+		for _, a := range append(append([]*archive.Archive{}, r.archives...), r.indeterminates...) {
+			if a.Header().Version < archive.MutationVersion {
+				fmt.Printf("Archive %s is written by old version, it can't record update or delete\n",
+					a.FileName())
+				return archive.ErrMutationNotSupported
+			}
+		}
+	}
+	// Ids of indeterminate entries may be not in testing table, never reuse them.
+	nextIndeterminateId, err := r.nextIndeterminateId()
+	if err != nil {
+		return err
+	}
+	if nextIndeterminateId > maxId {
+		maxId = nextIndeterminateId
+	}
+	ids := newIdAllocator(maxId, cfg.InsertRows)
+	progress := newInsertProgress(cfg.InsertRows)
+	// Record the run before inserting, an unfinished run has no end time.
+	manifest := r.newManifest(originManifest)
+	run := RunManifest{
+		RunId:    r.runId,
+		Start:    time.Now(),
+		FirstId:  maxId,
+		Archived: cfg.ArchiveData,
+	}
+	if r.gen != nil {
+		run.SeedHash = r.gen.seedHash()
+	}
+	manifest.Runs = append(manifest.Runs, run)
+	err = r.storeManifest(manifest)
+	if err != nil {
+		fmt.Println("Store manifest failed, err:", err)
+		return err
+	}
+	// inserted is the number of committed rows, endId is the max id tried to insert + 1.
+	inserted, endId := uint64(0), maxId
+	// absent and indeterminate are the number of rows failed to insert.
+	absent, indeterminate := uint64(0), uint64(0)
+	// unknown is ids which may be not inserted, they are recorded if rows aren't archived.
+	var unknown []IdRange
+	unknownMu := sync.Mutex{}
+	if cfg.InsertMethod == config.InsertMethodPrepared {
+		r.stmts = make([]*stmtCache, 0, cfg.RoutineNum)
+		for i := 0; i < int(cfg.RoutineNum); i++ {
+			r.stmts = append(r.stmts, newStmtCache(r.dbs[i]))
+		}
+		defer r.closeStmts()
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(int(cfg.RoutineNum))
+	// Seek archive for append entries
+	for _, a := range append(r.archives, r.indeterminates...) {
+		err := a.SeekForAppend()
+		if err != nil {
+			fmt.Println("Seek for append entries failed, err:", err)
+			return err
+		}
+	}
+	stopReport := r.startReport("insert")
+	defer stopReport()
+	sched, ctx, stopSchedule := r.startSchedule(ctx)
+	defer stopSchedule()
+	// Insert test data to testing database, routines stop when ctx is canceled or all ids are leased.
+	for i := 0; i < int(cfg.RoutineNum); i++ {
+		go func(routineId int) {
+			defer wg.Done()
+			// Ids left in the lease of a parked routine are never inserted, other routines can't take them
+			// and keep their ids ascending. So if stages park routines, a routine leases ids per transaction.
+			claims := uint64(leaseClaims)
+			if sched.parks() {
+				claims = 1
+			}
+			lease := newIdLease(ids, claims)
+			live := newLiveRows()
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(routineId)))
+			for sched.wait(ctx, routineId) {
+				st := sched.stage()
+				op := archive.OpInsert
+				if cfg.Mixed() && len(live.ids) != 0 {
+					op = r.chooseOp(rnd)
+				}
+				// Update and delete change a row, an insert has all rows of its transaction.
+				rows := uint64(1)
+				if op == archive.OpInsert {
+					rows = uint64(st.insertPackage) * uint64(r.txStatements())
+				}
+				// With rate, start is the intended start time and latency is measured from it.
+				start, ok := st.begin(ctx, rows)
+				if !ok {
+					break
+				}
+				if op != archive.OpInsert {
+					r.mutate(routineId, live, op, rnd, start)
+					sleepContext(ctx, st.insertDelay)
+					continue
+				}
+				firstId, count := lease.take(rows)
+				if count == 0 {
+					// All ids are leased, other routines insert the rest of their leases.
+					sched.finish()
+					break
+				}
+				progress.add(count)
+
+				// Generate insert sql
+				entries := make([]*archive.Entry, 0, count)
+				for id := firstId; id < firstId+count; id++ {
+					entry := &archive.Entry{Id: id}
+					r.fillEntry(entry)
+					entries = append(entries, entry)
+				}
+				result := r.insertEntries(routineId, entries, st.insertPackage)
+				r.record("insert", start, result == insertCommitted)
+				storeMaxUint64(&endId, firstId+count)
+				switch result {
+				case insertAbsent:
+					atomic.AddUint64(&absent, count)
+					r.metrics.insertErrors.With("absent").Inc()
+				case insertIndeterminate:
+					atomic.AddUint64(&indeterminate, count)
+					r.metrics.insertErrors.With("indeterminate").Inc()
+				}
+				if result != insertCommitted && !cfg.ArchiveData {
+					unknownMu.Lock()
+					unknown = append(unknown, IdRange{First: firstId, End: firstId + count})
+					unknownMu.Unlock()
+				}
+				switch result {
+				case insertCommitted:
+					atomic.AddUint64(&inserted, count)
+					r.metrics.rowsInserted.Add(count)
+					if cfg.Mixed() {
+						live.add(entries)
+					}
+					if !cfg.ArchiveData {
+						break
+					}
+					err := r.appendArchive(r.archives[routineId], entries)
+					if err != nil {
+						zlog.ErrorF("id: %d, uuid: %s insert success, but append to archive failed",
+							firstId, entries[0].Uuid)
+						fmt.Printf("id: %d, uuid: %s insert success, but append to archive failed\n",
+							firstId, entries[0].Uuid)
+					}
+				case insertIndeterminate:
+					// Check will find out whether they are written.
+					err := r.appendArchive(r.indeterminates[routineId], entries)
+					if err != nil {
+						zlog.ErrorF("id: %d, uuid: %s insert failed, and append to indeterminate archive failed",
+							firstId, entries[0].Uuid)
+						fmt.Printf("id: %d, uuid: %s insert failed, and append to indeterminate archive failed\n",
+							firstId, entries[0].Uuid)
+					}
+				}
+				sleepContext(ctx, st.insertDelay)
+			}
+			// Ids left in lease are skipped, they may be below ids inserted by other routines.
+			if remaining := lease.remaining(); remaining.First != remaining.End && !cfg.ArchiveData {
+				unknownMu.Lock()
+				unknown = append(unknown, remaining)
+				unknownMu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	stopSchedule()
+	stopReport()
+	// Record inserted entries and end of the run.
+	for _, a := range r.archives {
+		a.EntityNum = 0
+		a.Flush()
+	}
+	for _, a := range r.indeterminates {
+		a.EntityNum = 0
+		a.Flush()
+	}
+	end := time.Now()
+	elapsed := end.Sub(run.Start).Seconds()
+	fmt.Printf("Inserted %d rows in %.2fs (%.0f rows/s), insert method is %s\n",
+		inserted, elapsed, float64(inserted)/elapsed, insertMethod(cfg))
+	zlog.InfoF("Inserted %d rows in %.2fs (%.0f rows/s), insert method is %s",
+		inserted, elapsed, float64(inserted)/elapsed, insertMethod(cfg))
+	phase.Counts["inserted"] = inserted
+	phase.Counts["absent"] = absent
+	phase.Counts["indeterminate"] = indeterminate
+	run.End = &end
+	run.Inserted = inserted
+	run.EndId = endId
+	sort.Slice(unknown, func(i, j int) bool {
+		return unknown[i].First < unknown[j].First
+	})
+	run.Unknown = unknown
+	manifest = r.newManifest(manifest)
+	manifest.Runs[len(manifest.Runs)-1] = run
+	err = r.storeManifest(manifest)
+	if err != nil {
+		fmt.Println("Store manifest failed, err:", err)
+	}
+	return nil
+}
+
+// insertResult is the result of inserting entries.
+type insertResult int
+
+const (
+	insertCommitted insertResult = iota
+	// insertAbsent means entries aren't written, e.g. transaction is rolled back.
+	insertAbsent
+	// insertIndeterminate means entries may be written or not, e.g. connection is broken when committing.
+	insertIndeterminate
+)
+
+// txStatements returns number of insert statements in a transaction, 1 for autocommit.
+func (r *Runner) txStatements() uint {
+	if r.cfg.TxStatements == 0 {
+		return 1
+	}
+	return r.cfg.TxStatements
+}
+
+// insertEntries inserts entries of routine, every statement inserts insertPackage entries.
+// Without explicit transaction, there is only one statement in autocommit mode.
+// Otherwise, all statements are in a transaction with isolation level of config.
+// Bulk load is always in a transaction, entries are written only if it's committed.
+func (r *Runner) insertEntries(routineId int, entries []*archive.Entry, insertPackage uint) insertResult {
+	cfg := r.cfg
+	firstId, endId := entries[0].Id, entries[len(entries)-1].Id+1
+	// Don't use ctx, the claimed ids must be inserted.
+	if cfg.TxStatements == 0 && cfg.InsertMethod != config.InsertMethodBulk {
+		err := r.execInsert(routineId, nil, entries)
+		if err != nil {
+			// Statement may be committed before the error, e.g. connection is broken.
+			zlog.ErrorF("Routine %d commit testing sql failed, ids [%d, %d) are indeterminate, err: %s",
+				routineId, firstId, endId, err)
+			fmt.Printf("Routine %d commit testing sql failed, err: %s\n", routineId, err)
+			return insertIndeterminate
+		}
+		return insertCommitted
+	}
+
+	txOptions, err := operator.TxOptions(r.op, cfg.IsolationLevel())
+	if err == nil {
+		var tx *sql.Tx
+		tx, err = r.dbs[routineId].BeginTx(context.Background(), txOptions)
+		if err == nil {
+			return r.insertInTx(routineId, tx, entries, insertPackage)
+		}
+	}
+	zlog.ErrorF("Routine %d begin transaction failed, ids [%d, %d) aren't inserted, err: %s",
+		routineId, firstId, endId, err)
+	fmt.Printf("Routine %d begin transaction failed, err: %s\n", routineId, err)
+	return insertAbsent
+}
+
+// insertInTx inserts entries in tx, then commits it. Entries are written only if commit succeeds.
+// Error of commit or rollback makes them indeterminate.
+func (r *Runner) insertInTx(routineId int, tx *sql.Tx, entries []*archive.Entry, insertPackage uint) insertResult {
+	firstId, endId := entries[0].Id, entries[len(entries)-1].Id+1
+	if insertPackage == 0 {
+		insertPackage = 1
+	}
+	for begin := 0; begin < len(entries); begin += int(insertPackage) {
+		end := begin + int(insertPackage)
+		if end > len(entries) {
+			end = len(entries)
+		}
+		err := r.execInsert(routineId, tx, entries[begin:end])
+		if err == nil {
+			continue
+		}
+		fmt.Printf("Routine %d exec testing sql in transaction failed, err: %s\n", routineId, err)
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			zlog.ErrorF("Routine %d rollback failed, ids [%d, %d) are indeterminate, err: %s, rollback err: %s",
+				routineId, firstId, endId, err, rollbackErr)
+			return insertIndeterminate
+		}
+		zlog.ErrorF("Routine %d transaction is rolled back, ids [%d, %d) aren't inserted, err: %s",
+			routineId, firstId, endId, err)
+		return insertAbsent
+	}
+	err := tx.Commit()
+	if err != nil {
+		zlog.ErrorF("Routine %d commit transaction failed, ids [%d, %d) are indeterminate, err: %s",
+			routineId, firstId, endId, err)
+		fmt.Printf("Routine %d commit transaction failed, err: %s\n", routineId, err)
+		return insertIndeterminate
+	}
+	return insertCommitted
+}
+
+// nextIndeterminateId returns max id of indeterminate archives + 1, 0 if there is no entry.
+func (r *Runner) nextIndeterminateId() (uint64, error) {
+	nextId := uint64(0)
+	for _, a := range r.indeterminates {
+		a.SeekForRead()
+		for {
+			entry, err := a.GetOneEntry(r.cfg.ExtraColumnNum)
+			if err != nil {
+				if errors.Is(err, archive.ErrReadEndOfFile) {
+					break
+				}
+				fmt.Println("Read indeterminate archive failed, err:", err)
+				return 0, err
+			}
+			if entry.Id+1 > nextId {
+				nextId = entry.Id + 1
+			}
+		}
+	}
+	return nextId, nil
+}
+
+// storeMaxUint64 stores v to addr if it's bigger.
+func storeMaxUint64(addr *uint64, v uint64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if v <= old || atomic.CompareAndSwapUint64(addr, old, v) {
+			return
+		}
+	}
+}
+
+// sleepContext sleeps d, and wakes up when ctx is canceled.
+func sleepContext(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
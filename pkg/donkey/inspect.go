@@ -0,0 +1,216 @@
+package donkey
+
+import (
+	"donkey/pkg/archive"
+	"donkey/pkg/config"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveFiles returns archive and indeterminate archive files in dir, sorted by kind then routine id.
+func archiveFiles(dir string) ([]string, error) {
+	var files []string
+	for _, prefix := range []string{archive.FilePrefix, archive.IndeterminateFilePrefix} {
+		matches, err := filepath.Glob(filepath.Join(dir, prefix+"*"))
+		if err != nil {
+			return nil, err
+		}
+		routineId := func(file string) int {
+			id, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(file), prefix))
+			return id
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			return routineId(matches[i]) < routineId(matches[j])
+		})
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// InspectOptions chooses what Inspect prints.
+type InspectOptions struct {
+	// Format dumps all entries in format (archive.FormatJSON or archive.FormatCSV) if it's not empty.
+	Format string
+	// Ids looks up entries of ids in all archives, they are printed in Format, or as text if it's empty.
+	Ids []uint64
+}
+
+// Inspect prints manifest and archives in work dir of config, files are never changed.
+// Without options, it prints manifest and stat of every archive, and validates every record.
+// It returns an error matching archive.ErrArchiveCorrupted if any archive is corrupted.
+func Inspect(cfg *config.Config, w io.Writer, opts InspectOptions) error {
+	m, err := readManifestFile(filepath.Join(cfg.WorkDir, manifestFileName), false)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	files, err := archiveFiles(cfg.WorkDir)
+	if err != nil {
+		fmt.Println("List archive files failed, err:", err)
+		return err
+	}
+	extraNum := archiveExtraColumnNum(files, cfg.ExtraColumnNum)
+	var writer archive.EntryWriter
+	if opts.Format != "" {
+		writer, err = archive.NewEntryWriter(w, opts.Format, extraNum)
+		if err != nil {
+			return err
+		}
+	}
+	if len(opts.Ids) != 0 {
+		return lookupIds(w, writer, m, files, extraNum, opts.Ids)
+	}
+	if writer != nil {
+		return dumpEntries(writer, files, extraNum)
+	}
+
+	if m == nil {
+		_, _ = fmt.Fprintln(w, "No manifest in work dir.")
+	} else {
+		printManifest(w, m)
+	}
+	var corrupted error
+	for _, file := range files {
+		stat, err := archive.Scan(file, extraNum, nil)
+		if err != nil {
+			fmt.Printf("Read archive %s failed, err: %s\n", file, err)
+			return err
+		}
+		_, _ = fmt.Fprintf(w, "Archive %s: version %d, run %s, %d bytes, %d entries",
+			filepath.Base(file), stat.Header.Version, stat.Header.RunId, stat.Size, stat.Entries)
+		if stat.Entries != 0 {
+			_, _ = fmt.Fprintf(w, " (insert %d, update %d, delete %d), ids [%d, %d]",
+				stat.Inserts, stat.Updates, stat.Deletes, stat.MinId, stat.MaxId)
+		}
+		if stat.TornBytes != 0 {
+			_, _ = fmt.Fprintf(w, ", torn tail %d bytes", stat.TornBytes)
+		}
+		_, _ = fmt.Fprintln(w)
+		if stat.Corrupted != nil {
+			_, _ = fmt.Fprintf(w, "  Corrupted: %s\n", stat.Corrupted)
+			corrupted = stat.Corrupted
+		}
+	}
+	return corrupted
+}
+
+// archiveExtraColumnNum returns extra column number in header of archives, or extraNum if all of them are legacy.
+func archiveExtraColumnNum(files []string, extraNum uint) uint {
+	for _, file := range files {
+		a, err := archive.OpenReadOnly(file, extraNum)
+		if err != nil {
+			continue
+		}
+		a.Close()
+		if a.Header().Version != archive.LegacyVersion {
+			return a.ExtraColumnNum()
+		}
+	}
+	return extraNum
+}
+
+// dumpEntries writes all entries of archive files, it stops at the first corrupted record of a file.
+func dumpEntries(writer archive.EntryWriter, files []string, extraNum uint) error {
+	var corrupted error
+	for _, file := range files {
+		base := filepath.Base(file)
+		stat, err := archive.Scan(file, extraNum, func(entry *archive.Entry, offset int64) error {
+			return writer.Write(base, offset, entry)
+		})
+		if err != nil {
+			fmt.Printf("Dump archive %s failed, err: %s\n", file, err)
+			return err
+		}
+		if stat.Corrupted != nil {
+			corrupted = stat.Corrupted
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return corrupted
+}
+
+// lookupIds prints entries of ids in all archive files, and ids which aren't in any archive.
+// Entries are printed in order of files, so the last one of an archive is the latest state of row.
+func lookupIds(w io.Writer, writer archive.EntryWriter, m *Manifest, files []string, extraNum uint, ids []uint64) error {
+	wanted := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = false
+	}
+	var corrupted error
+	for _, file := range files {
+		base := filepath.Base(file)
+		stat, err := archive.Scan(file, extraNum, func(entry *archive.Entry, offset int64) error {
+			if _, ok := wanted[entry.Id]; !ok {
+				return nil
+			}
+			wanted[entry.Id] = true
+			if writer != nil {
+				return writer.Write(base, offset, entry)
+			}
+			_, err := fmt.Fprintf(w, "%s offset %d: %s id %d version %d uuid %s extra %v\n",
+				base, offset, entry.Op, entry.Id, entry.Version, entry.Uuid, entry.ExtraUuid)
+			return err
+		})
+		if err != nil {
+			fmt.Printf("Read archive %s failed, err: %s\n", file, err)
+			return err
+		}
+		if stat.Corrupted != nil {
+			corrupted = stat.Corrupted
+		}
+	}
+	if writer != nil {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+	var unarchived idRanges
+	if m != nil {
+		unarchived = unarchivedRanges(m)
+	}
+	// Output of writer is only entries.
+	out := w
+	if writer != nil {
+		out = os.Stdout
+	}
+	for _, id := range ids {
+		if wanted[id] {
+			continue
+		}
+		if unarchived.contains(id) {
+			_, _ = fmt.Fprintf(out, "Id %d is inserted by a run without archive, its values are derived from seed\n", id)
+		} else {
+			_, _ = fmt.Fprintf(out, "Id %d isn't in any archive\n", id)
+		}
+	}
+	if corrupted != nil {
+		fmt.Println("Some archives are corrupted, entries after the corrupted record are not looked up, err:", corrupted)
+	}
+	return corrupted
+}
+
+func printManifest(w io.Writer, m *Manifest) {
+	_, _ = fmt.Fprintf(w, "Manifest: table %s, %d routines, %d extra columns, %d archived entries, %d unarchived rows\n",
+		m.Table, m.RoutineNum, m.ExtraColumnNum, m.TotalEntries(), m.UnarchivedRows())
+	for _, run := range m.Runs {
+		end := "unfinished"
+		if run.End != nil {
+			end = run.End.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(w, "Run %s: %s - %s, inserted %d, ids [%d, %d), archived %v",
+			run.RunId, run.Start.Format(time.RFC3339), end, run.Inserted, run.FirstId, run.EndId, run.Archived)
+		if len(run.Unknown) != 0 {
+			_, _ = fmt.Fprintf(w, ", %d unknown ranges", len(run.Unknown))
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,66 @@
+package donkey
+
+import (
+	"donkey/pkg/stats"
+	"fmt"
+	"sync"
+	"time"
+
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+// startReport resets latency, then prints throughput and latency of phase every report interval.
+// It returns a function stopping the report and printing summary of phase, calling it again does nothing.
+func (r *Runner) startReport(phase string) func() {
+	r.latency.Reset()
+	begin := time.Now()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if r.cfg.ReportInterval == 0 {
+			<-done
+			return
+		}
+		interval := time.Duration(r.cfg.ReportInterval) * time.Second
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := begin
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				for _, s := range r.latency.TakeInterval() {
+					printOpStat(fmt.Sprintf("[%s %s]", phase, now.Sub(begin).Round(time.Second)), s, now.Sub(last), false)
+				}
+				last = now
+			}
+		}
+	}()
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			elapsed := time.Since(begin)
+			for _, s := range r.latency.Total() {
+				printOpStat(fmt.Sprintf("[%s summary]", phase), s, elapsed, true)
+			}
+		})
+	}
+}
+
+// printOpStat prints throughput and latency of successful and failed operations.
+func printOpStat(prefix string, s *stats.OpStat, elapsed time.Duration, log bool) {
+	lines := []string{fmt.Sprintf("%s %s ok: %s", prefix, s.Op, stats.Format(s.Ok, elapsed))}
+	if s.Err.Count() != 0 {
+		lines = append(lines, fmt.Sprintf("%s %s error: %s", prefix, s.Op, stats.Format(s.Err, elapsed)))
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+		if log {
+			zlog.InfoF("%s", line)
+		}
+	}
+}
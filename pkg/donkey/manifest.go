@@ -0,0 +1,295 @@
+package donkey
+
+import (
+	"donkey/pkg/archive/codec"
+	"donkey/pkg/operator"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestVersion is the version of manifest format.
+const manifestVersion = 1
+
+const (
+	// manifestFileName is the name of manifest file in work dir.
+	manifestFileName = "donkey_manifest.json"
+	// legacyEntryNumFileName is the name of entry number file of old versions in work dir.
+	legacyEntryNumFileName = "entry_num"
+)
+
+var (
+	ErrManifestCorrupted = errors.New("manifest is corrupted")
+	ErrManifestMismatch  = errors.New("manifest is different from config")
+	ErrArchiveDataLost   = errors.New("archive has less data than manifest")
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Manifest records what is in archives of work dir, and the config produced them.
+// It's written to a temp file then renamed, so it's either the old one or the new one.
+type Manifest struct {
+	Version        int    `json:"version"`
+	Table          string `json:"table"`
+	RoutineNum     uint   `json:"routine-num"`
+	ExtraColumnNum uint   `json:"extra-column-num"`
+	// Routines is the archive state of every routine, index is routine id.
+	Routines []RoutineManifest `json:"routines"`
+	Runs     []RunManifest     `json:"runs"`
+	// Checksum is crc32c (hex) of manifest json with empty checksum.
+	Checksum string `json:"checksum"`
+}
+
+// RoutineManifest is the archive state of a routine.
+// Offset is the size of archive file, 0 means unknown (from legacy entry_num).
+type RoutineManifest struct {
+	Entries              uint64 `json:"entries"`
+	ArchiveOffset        int64  `json:"archive-offset"`
+	IndeterminateEntries uint64 `json:"indeterminate-entries"`
+	IndeterminateOffset  int64  `json:"indeterminate-offset"`
+}
+
+// RunManifest is an insert run. End is nil if the run didn't finish, e.g. it was killed.
+type RunManifest struct {
+	RunId    string     `json:"run-id"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"`
+	Inserted uint64     `json:"inserted"`
+	// Ids in [FirstId, EndId) are tried to insert by the run.
+	FirstId uint64 `json:"first-id"`
+	EndId   uint64 `json:"end-id"`
+	// Archived is false if inserted rows aren't archived, they are checked with seed.
+	Archived bool   `json:"archived"`
+	SeedHash string `json:"seed-hash,omitempty"`
+	// Unknown is sorted ids which may be not inserted, it's only recorded without archive.
+	Unknown []IdRange `json:"unknown,omitempty"`
+}
+
+// IdRange is ids in [First, End).
+type IdRange struct {
+	First uint64 `json:"first"`
+	End   uint64 `json:"end"`
+}
+
+// UnarchivedRows returns the number of rows inserted by runs without archive.
+func (m *Manifest) UnarchivedRows() uint64 {
+	total := uint64(0)
+	for _, run := range m.Runs {
+		if !run.Archived {
+			total += run.Inserted
+		}
+	}
+	return total
+}
+
+// TotalEntries returns the number of entries in all archives.
+func (m *Manifest) TotalEntries() uint64 {
+	total := uint64(0)
+	for _, routine := range m.Routines {
+		total += routine.Entries
+	}
+	return total
+}
+
+func (m *Manifest) checksum() (string, error) {
+	c := *m
+	c.Checksum = ""
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", crc32.Checksum(data, crc32cTable)), nil
+}
+
+func (r *Runner) manifestFile() string {
+	return filepath.Join(r.cfg.WorkDir, manifestFileName)
+}
+
+// legacyEntryNumFile is the entry number file of old versions, it's read if there is no manifest.
+func (r *Runner) legacyEntryNumFile() string {
+	return filepath.Join(r.cfg.WorkDir, legacyEntryNumFileName)
+}
+
+// newManifest returns manifest of current archives, runs are copied from old.
+func (r *Runner) newManifest(old *Manifest) *Manifest {
+	m := &Manifest{
+		Version:        manifestVersion,
+		Table:          operator.TestingTable,
+		RoutineNum:     r.cfg.RoutineNum,
+		ExtraColumnNum: r.cfg.ExtraColumnNum,
+		Routines:       make([]RoutineManifest, 0, r.cfg.RoutineNum),
+	}
+	for i := range r.archives {
+		m.Routines = append(m.Routines, RoutineManifest{
+			Entries:              r.archives[i].EntryCount(),
+			ArchiveOffset:        r.archives[i].Size(),
+			IndeterminateEntries: r.indeterminates[i].EntryCount(),
+			IndeterminateOffset:  r.indeterminates[i].Size(),
+		})
+	}
+	if old != nil {
+		m.Runs = append(m.Runs, old.Runs...)
+	}
+	return m
+}
+
+// storeManifest writes manifest to a temp file, syncs it, then renames it to manifest file.
+func (r *Runner) storeManifest(m *Manifest) error {
+	var err error
+	m.Checksum, err = m.checksum()
+	if err != nil {
+		fmt.Println("Encode manifest failed, err:", err)
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		fmt.Println("Encode manifest failed, err:", err)
+		return err
+	}
+	tmpFile := r.manifestFile() + ".tmp"
+	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		fmt.Println("Open manifest temp file failed, err:", err)
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Println("Write manifest temp file failed, err:", err)
+		return err
+	}
+	err = os.Rename(tmpFile, r.manifestFile())
+	if err != nil {
+		fmt.Println("Rename manifest file failed, err:", err)
+		return err
+	}
+	// Sync dir to persist the rename.
+	dir, err := os.Open(r.cfg.WorkDir)
+	if err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+	// Manifest replaces entry number file of old versions.
+	_ = os.Remove(r.legacyEntryNumFile())
+	return nil
+}
+
+// readManifest reads manifest of work dir, and checks it's produced by the same config.
+// Entry number file of old versions is read if there is no manifest.
+// It returns an error matching fs.ErrNotExist if there is neither.
+func (r *Runner) readManifest(quiet bool) (*Manifest, error) {
+	m, err := readManifestFile(r.manifestFile(), quiet)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r.readLegacyEntryNum(quiet)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.RoutineNum != r.cfg.RoutineNum || len(m.Routines) != int(r.cfg.RoutineNum) {
+		fmt.Printf("Routine number is different in two tasks. Manifest is [%d], config is [%d]\n",
+			m.RoutineNum, r.cfg.RoutineNum)
+		return nil, ErrDifferentRoutineNum
+	}
+	if m.ExtraColumnNum != r.cfg.ExtraColumnNum || m.Table != operator.TestingTable {
+		fmt.Printf("Manifest is produced by table %s with %d extra columns, config is table %s with %d\n",
+			m.Table, m.ExtraColumnNum, operator.TestingTable, r.cfg.ExtraColumnNum)
+		return nil, ErrManifestMismatch
+	}
+	return m, nil
+}
+
+// readManifestFile reads manifest file and verifies its checksum.
+func readManifestFile(fileName string, quiet bool) (*Manifest, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		if !quiet && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("Read manifest failed, err:", err)
+		}
+		return nil, err
+	}
+	m := &Manifest{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		fmt.Println("Manifest is corrupted, err:", err)
+		return nil, ErrManifestCorrupted
+	}
+	checksum, err := m.checksum()
+	if err != nil || checksum != m.Checksum {
+		fmt.Printf("Manifest is corrupted, checksum is %s, expect %s\n", checksum, m.Checksum)
+		return nil, ErrManifestCorrupted
+	}
+	return m, nil
+}
+
+// readLegacyEntryNum reads entry number file of old versions as a manifest.
+func (r *Runner) readLegacyEntryNum(quiet bool) (*Manifest, error) {
+	routineNum := r.cfg.RoutineNum
+	data, err := os.ReadFile(r.legacyEntryNumFile())
+	if err != nil {
+		if !quiet {
+			fmt.Println("Read manifest failed, err:", err)
+		}
+		return nil, err
+	}
+	index := 0
+	if (len(data) - index) < 8 {
+		fmt.Println("Entry number file is incomplete")
+		return nil, ErrEntryNumFileIncomplete
+	}
+	numOfEntries := codec.DecodeFixedUint64(codec.GetFixedUint64(data, index))
+	if numOfEntries != uint64(routineNum) {
+		fmt.Println("Routine number is different in two tasks.")
+		return nil, ErrDifferentRoutineNum
+	}
+	index += 8
+	m := &Manifest{
+		Version:        manifestVersion,
+		Table:          operator.TestingTable,
+		RoutineNum:     routineNum,
+		ExtraColumnNum: r.cfg.ExtraColumnNum,
+		Routines:       make([]RoutineManifest, 0, routineNum),
+	}
+	for i := 0; i < int(routineNum); i++ {
+		if (len(data) - index) < 8 {
+			fmt.Println("Entry number file is incomplete")
+			return nil, ErrEntryNumFileIncomplete
+		}
+		entryNum := codec.DecodeFixedUint64(codec.GetFixedUint64(data, index))
+		index += 8
+		m.Routines = append(m.Routines, RoutineManifest{Entries: entryNum})
+	}
+	return m, nil
+}
+
+// validateManifest checks archives have all data recorded in manifest.
+// Archives may have more data, if the last run was killed before storing manifest.
+func (r *Runner) validateManifest(m *Manifest) error {
+	for i, routine := range m.Routines {
+		a := r.archives[i]
+		indeterminate := r.indeterminates[i]
+		if a.EntryCount() < routine.Entries || a.Size() < routine.ArchiveOffset {
+			fmt.Printf("Archive of routine [%d] has %d entries (%d bytes), but manifest records %d (%d bytes)\n",
+				i, a.EntryCount(), a.Size(), routine.Entries, routine.ArchiveOffset)
+			return ErrArchiveDataLost
+		}
+		if indeterminate.EntryCount() < routine.IndeterminateEntries ||
+			indeterminate.Size() < routine.IndeterminateOffset {
+			fmt.Printf("Indeterminate archive of routine [%d] has %d entries (%d bytes), "+
+				"but manifest records %d (%d bytes)\n", i, indeterminate.EntryCount(), indeterminate.Size(),
+				routine.IndeterminateEntries, routine.IndeterminateOffset)
+			return ErrArchiveDataLost
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package donkey
+
+import (
+	"context"
+	"donkey/pkg/archive/codec"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifest_Runs(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	runDonkey(t, cfg).Close()
+	r := runDonkey(t, cfg)
+
+	m, err := r.readManifest(false)
+	if err != nil {
+		t.Fatal("Read manifest failed, err:", err)
+	}
+	if m.TotalEntries() != 2*cfg.InsertRows {
+		t.Errorf("Manifest has %d entries, expect %d", m.TotalEntries(), 2*cfg.InsertRows)
+	}
+	if len(m.Runs) != 2 {
+		t.Fatalf("Manifest has %d runs, expect 2", len(m.Runs))
+	}
+	for _, run := range m.Runs {
+		if run.End == nil || run.Inserted != cfg.InsertRows {
+			t.Errorf("Run %s isn't finished correctly: %+v", run.RunId, run)
+		}
+	}
+	for i, routine := range m.Routines {
+		if routine.ArchiveOffset != r.archives[i].Size() {
+			t.Errorf("Routine [%d] archive offset is %d, expect %d",
+				i, routine.ArchiveOffset, r.archives[i].Size())
+		}
+	}
+	if _, err = os.Stat(r.manifestFile() + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Temp file of manifest should be renamed, err:", err)
+	}
+}
+
+func TestManifest_Corrupted(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	runDonkey(t, cfg).Close()
+
+	fileName := filepath.Join(cfg.WorkDir, "donkey_manifest.json")
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal("Read manifest file failed, err:", err)
+	}
+	data = []byte(strings.Replace(string(data), `"extra-column-num": 2`, `"extra-column-num": 3`, 1))
+	if err = os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal("Write manifest file failed, err:", err)
+	}
+	r := newRunner(t, cfg)
+	if err = r.Insert(context.Background()); !errors.Is(err, ErrManifestCorrupted) {
+		t.Error("Insert should fail with corrupted manifest, err:", err)
+	}
+}
+
+func TestManifest_LegacyEntryNum(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	r := runDonkey(t, cfg)
+	m, err := r.readManifest(false)
+	if err != nil {
+		t.Fatal("Read manifest failed, err:", err)
+	}
+	r.Close()
+
+	// Work dir of old versions has entry_num instead of manifest.
+	data := make([]byte, 0, 8*(len(m.Routines)+1))
+	num := codec.EncodeFixedUint64(uint64(len(m.Routines)))
+	data = append(data, num[:]...)
+	for _, routine := range m.Routines {
+		num = codec.EncodeFixedUint64(routine.Entries)
+		data = append(data, num[:]...)
+	}
+	if err = os.Remove(r.manifestFile()); err != nil {
+		t.Fatal("Remove manifest failed, err:", err)
+	}
+	if err = os.WriteFile(r.legacyEntryNumFile(), data, 0644); err != nil {
+		t.Fatal("Write entry number file failed, err:", err)
+	}
+
+	r = runDonkey(t, cfg)
+	m, err = r.readManifest(false)
+	if err != nil {
+		t.Fatal("Read manifest failed, err:", err)
+	}
+	if m.TotalEntries() != 2*cfg.InsertRows {
+		t.Errorf("Manifest has %d entries, expect %d", m.TotalEntries(), 2*cfg.InsertRows)
+	}
+	if _, err = os.Stat(r.legacyEntryNumFile()); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Entry number file should be replaced by manifest, err:", err)
+	}
+}
+
+func TestManifest_ArchiveDataLost(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	r := runDonkey(t, cfg)
+	// Truncate the biggest archive, a routine may insert nothing.
+	routineId := 0
+	for i, a := range r.archives {
+		if a.EntryCount() > r.archives[routineId].EntryCount() {
+			routineId = i
+		}
+	}
+	size := r.archives[routineId].Size()
+	r.Close()
+
+	fileName := filepath.Join(cfg.WorkDir, fmt.Sprintf("donkey_archive_%d", routineId))
+	if err := os.Truncate(fileName, size/2); err != nil {
+		t.Fatal("Truncate archive failed, err:", err)
+	}
+	r = newRunner(t, cfg)
+	if err := r.Insert(context.Background()); !errors.Is(err, ErrArchiveDataLost) {
+		t.Error("Insert should fail with lost archive data, err:", err)
+	}
+}
@@ -0,0 +1,88 @@
+package donkey
+
+import (
+	"donkey/pkg/archive"
+	"donkey/pkg/metrics"
+	"donkey/pkg/stats"
+	"fmt"
+	"time"
+
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+// Classes of check failures.
+const (
+	// failureData is a row lost or different from archive (or seed).
+	failureData = "data"
+	// failureIndeterminate is an indeterminate row different from the value tried to write.
+	failureIndeterminate = "indeterminate"
+	// failureReverse is a row in testing table which isn't written by donkey.
+	failureReverse = "reverse"
+	// failureArchive is an archive which is corrupted or has less data than manifest.
+	failureArchive = "archive"
+	// failureBalance is a wrong total balance of bank workload.
+	failureBalance = "balance"
+)
+
+// runnerMetrics is metrics of a runner, they are kept through all phases.
+type runnerMetrics struct {
+	registry *metrics.Registry
+	// latency is latency of all phases, latency of runner is only of the current phase.
+	latency        *stats.Recorder
+	routines       *metrics.Gauge
+	rowsInserted   *metrics.Counter
+	insertErrors   *metrics.CounterVec
+	archiveBytes   *metrics.Counter
+	checkedEntries *metrics.Counter
+	checkTotal     *metrics.Gauge
+	checkFailures  *metrics.CounterVec
+}
+
+func newRunnerMetrics() *runnerMetrics {
+	registry := metrics.NewRegistry()
+	m := &runnerMetrics{
+		registry:       registry,
+		latency:        stats.NewRecorder(),
+		routines:       registry.Gauge("donkey_routines", "Number of running routines."),
+		rowsInserted:   registry.Counter("donkey_rows_inserted_total", "Number of committed rows."),
+		insertErrors:   registry.CounterVec("donkey_insert_errors_total", "Number of failed writes by class.", "class"),
+		archiveBytes:   registry.Counter("donkey_archive_bytes_written_total", "Bytes appended to archives."),
+		checkedEntries: registry.Counter("donkey_check_entries_total", "Number of archive entries checked."),
+		checkTotal:     registry.Gauge("donkey_check_entries_expected", "Number of archive entries to check."),
+		checkFailures:  registry.CounterVec("donkey_check_failures_total", "Number of check failures by class.", "class"),
+	}
+	registry.Latency("donkey_operation_duration_seconds", "Latency of operations.", m.latency)
+	return m
+}
+
+// ServeMetrics serves Prometheus metrics on metrics address of config, until runner is closed.
+// It does nothing if the address is empty.
+func (r *Runner) ServeMetrics() error {
+	if r.cfg.MetricsAddr == "" {
+		return nil
+	}
+	server, err := metrics.Serve(r.cfg.MetricsAddr, r.metrics.registry)
+	if err != nil {
+		fmt.Println("Serve metrics failed, err:", err)
+		return err
+	}
+	r.metricsServer = server
+	fmt.Printf("Serving metrics on http://%s/metrics\n", server.Addr)
+	zlog.InfoF("Serving metrics on http://%s/metrics", server.Addr)
+	return nil
+}
+
+// record records latency of an operation began at start, for report of phase and metrics.
+func (r *Runner) record(op string, start time.Time, ok bool) {
+	d := time.Since(start)
+	r.latency.Record(op, d, ok)
+	r.metrics.latency.Record(op, d, ok)
+}
+
+// appendArchive appends entries to archive of a routine, and counts written bytes.
+func (r *Runner) appendArchive(a *archive.Archive, entries []*archive.Entry) error {
+	size := a.Size()
+	err := a.AppendEntries(entries)
+	r.metrics.archiveBytes.Add(uint64(a.Size() - size))
+	return err
+}
@@ -0,0 +1,325 @@
+package donkey
+
+import (
+	"context"
+	"database/sql"
+	"donkey/pkg/archive"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+// mutationWindow is the max number of recently inserted rows a routine updates or deletes.
+// A row is only mutated while it's in the last mutationWindow rows inserted by its routine,
+// so check knows the final state of a row once mutationWindow rows are inserted after it.
+const mutationWindow = 1024
+
+// liveRows is recently inserted rows of a routine and their versions.
+// A row is only mutated by the routine inserted it, so its versions are in order in one archive.
+type liveRows struct {
+	// recent is ids of the last mutationWindow inserted rows in a ring, next is the oldest one if it's full.
+	recent []uint64
+	next   int
+	// ids is rows of recent which are not deleted or failed, index is their position in ids.
+	ids      []uint64
+	index    map[uint64]int
+	versions map[uint64]uint64
+}
+
+func newLiveRows() *liveRows {
+	return &liveRows{
+		recent:   make([]uint64, 0, mutationWindow),
+		ids:      make([]uint64, 0, mutationWindow),
+		index:    make(map[uint64]int, mutationWindow),
+		versions: make(map[uint64]uint64, mutationWindow),
+	}
+}
+
+// add adds inserted rows, the oldest rows leave window if it's full.
+func (l *liveRows) add(entries []*archive.Entry) {
+	for _, entry := range entries {
+		if len(l.recent) < mutationWindow {
+			l.recent = append(l.recent, entry.Id)
+		} else {
+			if i, ok := l.index[l.recent[l.next]]; ok {
+				l.remove(i)
+			}
+			l.recent[l.next] = entry.Id
+			l.next = (l.next + 1) % mutationWindow
+		}
+		l.index[entry.Id] = len(l.ids)
+		l.ids = append(l.ids, entry.Id)
+		l.versions[entry.Id] = 0
+	}
+}
+
+// remove removes the i-th row, it's not mutated any more.
+func (l *liveRows) remove(i int) {
+	id, last := l.ids[i], l.ids[len(l.ids)-1]
+	l.ids[i] = last
+	l.index[last] = i
+	l.ids = l.ids[:len(l.ids)-1]
+	delete(l.index, id)
+	delete(l.versions, id)
+}
+
+// chooseOp chooses the next operation of routine by update and delete percent.
+func (r *Runner) chooseOp(rnd *rand.Rand) archive.Op {
+	n := uint(rnd.Intn(100))
+	switch {
+	case n < r.cfg.UpdatePercent:
+		return archive.OpUpdate
+	case n < r.cfg.UpdatePercent+r.cfg.DeletePercent:
+		return archive.OpDelete
+	default:
+		return archive.OpInsert
+	}
+}
+
+// mutate updates or deletes a random live row of routine, and archives the new state of row.
+// Failed operation is archived as indeterminate, and the row isn't mutated any more.
+// Latency is measured from start.
+func (r *Runner) mutate(routineId int, rows *liveRows, op archive.Op, rnd *rand.Rand, start time.Time) {
+	i := rnd.Intn(len(rows.ids))
+	id := rows.ids[i]
+	entry := &archive.Entry{
+		Id:      id,
+		Op:      op,
+		Version: rows.versions[id] + 1,
+	}
+	var execSql string
+	var args []interface{}
+	if op == archive.OpUpdate {
+		r.fillEntry(entry)
+		args = append(args, entry.Uuid)
+		for _, extraUuid := range entry.ExtraUuid {
+			args = append(args, extraUuid)
+		}
+		execSql = r.op.UpdateSQL(r.cfg)
+	} else {
+		execSql = r.op.DeleteSQL()
+	}
+	args = append(args, id)
+
+	result, err := r.dbs[routineId].Exec(execSql, args...)
+	r.record(op.String(), start, err == nil)
+	if err != nil {
+		zlog.ErrorF("Routine %d %s id [%d] version [%d] failed, it's indeterminate, err: %s",
+			routineId, op, id, entry.Version, err)
+		fmt.Printf("Routine %d %s testing sql failed, err: %s\n", routineId, op, err)
+		r.metrics.insertErrors.With("indeterminate").Inc()
+		rows.remove(i)
+		err = r.appendArchive(r.indeterminates[routineId], []*archive.Entry{entry})
+		if err != nil {
+			zlog.ErrorF("id: %d %s failed, and append to indeterminate archive failed", id, op)
+			fmt.Printf("id: %d %s failed, and append to indeterminate archive failed\n", id, op)
+		}
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected != 1 {
+		// The row inserted by this routine should be there, check will report it.
+		zlog.ErrorF("Routine %d %s id [%d] affected %d rows", routineId, op, id, affected)
+		fmt.Printf("Routine %d %s id %d affected %d rows\n", routineId, op, id, affected)
+	}
+	if op == archive.OpDelete {
+		rows.remove(i)
+	} else {
+		rows.versions[id] = entry.Version
+	}
+	err = r.appendArchive(r.archives[routineId], []*archive.Entry{entry})
+	if err != nil {
+		zlog.ErrorF("id: %d %s success, but append to archive failed", id, op)
+		fmt.Printf("id: %d %s success, but append to archive failed\n", id, op)
+	}
+}
+
+// rowWindow is the latest state of the last mutationWindow rows inserted in an archive, in insert order.
+// Rows leave window in the same order as liveRows of insert, so a row leaving window is in its final state,
+// and check keeps at most mutationWindow rows of a routine in memory.
+type rowWindow struct {
+	// ids is a ring of rows in window, head is the oldest one if it's full.
+	ids    []uint64
+	head   int
+	latest map[uint64]*archive.Entry
+}
+
+func newRowWindow() *rowWindow {
+	return &rowWindow{
+		ids:    make([]uint64, 0, mutationWindow),
+		latest: make(map[uint64]*archive.Entry, mutationWindow),
+	}
+}
+
+// push adds an inserted row, it returns the final state of the oldest row if it leaves window.
+func (w *rowWindow) push(entry *archive.Entry) *archive.Entry {
+	if len(w.ids) < mutationWindow {
+		w.ids = append(w.ids, entry.Id)
+		w.latest[entry.Id] = entry
+		return nil
+	}
+	oldest, ok := w.latest[w.ids[w.head]]
+	delete(w.latest, w.ids[w.head])
+	w.ids[w.head] = entry.Id
+	w.head = (w.head + 1) % mutationWindow
+	w.latest[entry.Id] = entry
+	if !ok {
+		return nil
+	}
+	return oldest
+}
+
+// update applies update or delete entry to its row, it returns false if row isn't in window.
+func (w *rowWindow) update(entry *archive.Entry) bool {
+	last, ok := w.latest[entry.Id]
+	if !ok {
+		return false
+	}
+	if entry.Version > last.Version {
+		w.latest[entry.Id] = entry
+	}
+	return true
+}
+
+// drain returns the final state of all rows in window in insert order, and empties window.
+func (w *rowWindow) drain() []*archive.Entry {
+	result := make([]*archive.Entry, 0, len(w.ids))
+	for i := range w.ids {
+		id := w.ids[(w.head+i)%len(w.ids)]
+		if entry, ok := w.latest[id]; ok {
+			result = append(result, entry)
+			delete(w.latest, id)
+		}
+	}
+	w.ids = w.ids[:0]
+	w.head = 0
+	return result
+}
+
+// readPending reads failed update and delete entries of routine from indeterminate archive,
+// row may be in the state of its last failed mutation. Failed operations are few, so they are read at first.
+func (r *Runner) readPending(routineId int) (map[uint64]*archive.Entry, error) {
+	pending := make(map[uint64]*archive.Entry)
+	a := r.indeterminates[routineId]
+	a.SeekForRead()
+	defer a.SeekForRead()
+	for {
+		entry, err := a.GetOneEntry(r.cfg.ExtraColumnNum)
+		if err != nil {
+			if errors.Is(err, archive.ErrReadEndOfFile) {
+				return pending, nil
+			}
+			return nil, err
+		}
+		if entry.Op == archive.OpInsert {
+			continue
+		}
+		if last, ok := pending[entry.Id]; !ok || entry.Version > last.Version {
+			pending[entry.Id] = entry
+		}
+	}
+}
+
+// checkFinalRows checks rows left window by their final state.
+// Rows never mutated are checked as inserted rows, the others by checkMutations.
+// It returns ids of failed rows.
+func (r *Runner) checkFinalRows(ctx context.Context, routineId int, rows []*archive.Entry,
+	pending map[uint64]*archive.Entry, batch int, stat *mutationStat) []uint64 {
+	inserted := make([]*archive.Entry, 0, len(rows))
+	var mutated []*archive.Entry
+	for _, row := range rows {
+		// Insert entry of a row which has only failed mutation is the latest state of row.
+		if _, ok := pending[row.Id]; ok || row.Op != archive.OpInsert {
+			mutated = append(mutated, row)
+		} else {
+			inserted = append(inserted, row)
+		}
+	}
+	var failedIds []uint64
+	if batch == 1 {
+		failedIds = r.checkEntriesOneByOne(ctx, routineId, inserted)
+	} else {
+		for len(inserted) != 0 && ctx.Err() == nil {
+			n := batch
+			if n > len(inserted) {
+				n = len(inserted)
+			}
+			failedIds = append(failedIds, r.checkEntriesInBatch(ctx, routineId, inserted[:n])...)
+			inserted = inserted[n:]
+		}
+	}
+	if len(mutated) != 0 && ctx.Err() == nil {
+		failedIds = append(failedIds, r.checkMutations(ctx, routineId, mutated, pending, stat)...)
+	}
+	return failedIds
+}
+
+// mutationStat is the number of checked mutated rows of all routines.
+type mutationStat struct {
+	updated       uint64
+	deleted       uint64
+	indeterminate uint64
+}
+
+// checkMutations checks every mutated row of routine is in its latest state in rows,
+// deleted row must be absent. Row with failed mutation may be in either state.
+// It returns ids of failed rows.
+func (r *Runner) checkMutations(ctx context.Context, routineId int, rows []*archive.Entry,
+	pending map[uint64]*archive.Entry, stat *mutationStat) []uint64 {
+	var failedIds []uint64
+	for _, latest := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+		id := latest.Id
+		row, err := r.lookupRow(ctx, routineId, id)
+		absent := errors.Is(err, sql.ErrNoRows)
+		if err != nil && !absent {
+			if ctx.Err() != nil {
+				break
+			}
+			r.reportLookupFailed(routineId, latest, err)
+			failedIds = append(failedIds, id)
+			continue
+		}
+		if inState(latest, row, absent) {
+			switch latest.Op {
+			case archive.OpUpdate:
+				atomic.AddUint64(&stat.updated, 1)
+			case archive.OpDelete:
+				atomic.AddUint64(&stat.deleted, 1)
+			}
+			continue
+		}
+		if pending, ok := pending[id]; ok && inState(pending, row, absent) {
+			atomic.AddUint64(&stat.indeterminate, 1)
+			zlog.InfoF("Indeterminate %s of id [%d] version [%d] is committed", pending.Op, id, pending.Version)
+			continue
+		}
+		failedIds = append(failedIds, id)
+		switch {
+		case latest.Op == archive.OpDelete:
+			fmt.Printf("Check failed: id %d is deleted but still in database\n", id)
+			zlog.ErrorF("Check failed: id [%d] is deleted (version [%d]) but still in database. Database: %s",
+				id, latest.Version, row[1:])
+		case absent:
+			r.reportLookupFailed(routineId, latest, sql.ErrNoRows)
+		default:
+			zlog.ErrorF("Check failed: id [%d] isn't in its latest state, %s version [%d]",
+				id, latest.Op, latest.Version)
+			r.sameAsRow(latest, row)
+		}
+	}
+	return failedIds
+}
+
+// inState reports whether row (or absent row) is in the state of entry.
+func inState(entry *archive.Entry, row [][]byte, absent bool) bool {
+	if entry.Op == archive.OpDelete {
+		return absent
+	}
+	return !absent && sameValues(entry, row)
+}
@@ -0,0 +1,38 @@
+package donkey
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pacer issues operations of all routines at a fixed rate of rows, for open-loop load.
+// Every operation has an intended start time on a fixed timetable, and latency is measured from it.
+// An operation is charged by its rows, so an insert of 100 rows takes the time of 100 single row inserts.
+// If the database is slow, operations start late and their latency includes the waiting,
+// so a slow operation doesn't hide delays of operations which should have started meanwhile.
+type pacer struct {
+	mu    sync.Mutex
+	rate  uint64
+	begin time.Time
+	// issued is the number of rows issued since begin.
+	issued uint64
+}
+
+func newPacer(rate uint64) *pacer {
+	return &pacer{rate: rate, begin: time.Now()}
+}
+
+// wait takes the next intended start time for an operation of rows, and waits until it.
+// It returns false if ctx is canceled while waiting.
+func (p *pacer) wait(ctx context.Context, rows uint64) (time.Time, bool) {
+	p.mu.Lock()
+	// Whole seconds and the rest are computed separately, so it doesn't overflow in long runs.
+	offset := time.Duration(p.issued/p.rate)*time.Second +
+		time.Duration(p.issued%p.rate)*time.Second/time.Duration(p.rate)
+	p.issued += rows
+	p.mu.Unlock()
+	intended := p.begin.Add(offset)
+	sleepContext(ctx, time.Until(intended))
+	return intended, ctx.Err() == nil
+}
@@ -0,0 +1,82 @@
+package donkey
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPacer(t *testing.T) {
+	p := newPacer(1000)
+	ctx := context.Background()
+	intended := make([]time.Time, 0, 100)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				at, ok := p.wait(ctx, 1)
+				if !ok {
+					t.Error("Pacer without canceling should not fail")
+				}
+				if time.Now().Before(at) {
+					t.Error("Operation starts before its intended time")
+				}
+				mu.Lock()
+				intended = append(intended, at)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// Intended times are on the timetable of rate, wherever routines are.
+	last := p.begin
+	for _, at := range intended {
+		if at.After(last) {
+			last = at
+		}
+	}
+	if offset := last.Sub(p.begin); offset != 99*time.Millisecond {
+		t.Errorf("The last intended time is %s after begin, expect 99ms", offset)
+	}
+
+	// An operation of several rows takes the time of them.
+	p = newPacer(10000)
+	operations := []struct {
+		rows   uint64
+		offset time.Duration
+	}{
+		{10, 0},
+		{25, time.Millisecond},
+		{1, 3500 * time.Microsecond},
+		{10, 3600 * time.Microsecond},
+	}
+	for _, op := range operations {
+		at, _ := p.wait(ctx, op.rows)
+		if offset := at.Sub(p.begin); offset != op.offset {
+			t.Errorf("Operation of %d rows is intended %s after begin, expect %s", op.rows, offset, op.offset)
+		}
+	}
+	if p.issued != 46 {
+		t.Errorf("Pacer issued %d rows, expect 46", p.issued)
+	}
+
+	// A late operation keeps its intended time, so waiting is in its latency.
+	p = newPacer(10)
+	p.begin = time.Now().Add(-time.Second)
+	at, _ := p.wait(ctx, 1)
+	if time.Since(at) < time.Second {
+		t.Errorf("Intended time of late operation is %s ago, expect 1s", time.Since(at))
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	p = newPacer(1)
+	p.wait(canceled, 1)
+	if _, ok := p.wait(canceled, 1); ok {
+		t.Error("Pacer should fail after canceling")
+	}
+}
@@ -0,0 +1,98 @@
+package donkey
+
+import (
+	"database/sql"
+	"donkey/pkg/archive"
+	"donkey/pkg/config"
+	"donkey/pkg/operator"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// stmtCache is prepared insert statements of a routine, keyed by number of rows.
+// A statement is prepared once, then values of every insert are bound to it.
+type stmtCache struct {
+	db    *sqlx.DB
+	stmts map[int]*sql.Stmt
+}
+
+func newStmtCache(db *sqlx.DB) *stmtCache {
+	return &stmtCache{db: db, stmts: make(map[int]*sql.Stmt)}
+}
+
+// get returns insert statement of n rows, it's prepared if it's not in cache.
+func (c *stmtCache) get(r *Runner, n int) (*sql.Stmt, error) {
+	if stmt, ok := c.stmts[n]; ok {
+		return stmt, nil
+	}
+	stmt, err := c.db.Prepare(r.op.PreparedInsertSQL(r.cfg, n))
+	if err != nil {
+		fmt.Printf("Prepare insert statement of %d rows failed, err: %s\n", n, err)
+		return nil, err
+	}
+	c.stmts[n] = stmt
+	return stmt, nil
+}
+
+func (c *stmtCache) close() {
+	for _, stmt := range c.stmts {
+		_ = stmt.Close()
+	}
+	c.stmts = make(map[int]*sql.Stmt)
+}
+
+func (r *Runner) closeStmts() {
+	for _, c := range r.stmts {
+		c.close()
+	}
+	r.stmts = nil
+}
+
+// insertMethod returns insert method of config, empty is text.
+func insertMethod(cfg *config.Config) string {
+	if cfg.InsertMethod == "" {
+		return config.InsertMethodText
+	}
+	return cfg.InsertMethod
+}
+
+// insertArgs returns values of entries as bind variables of prepared insert statement.
+func insertArgs(entries []*archive.Entry) []interface{} {
+	args := make([]interface{}, 0, len(entries)*(len(entries[0].ExtraUuid)+2))
+	for _, entry := range entries {
+		args = append(args, entry.Id, entry.Uuid)
+		for _, extraUuid := range entry.ExtraUuid {
+			args = append(args, extraUuid)
+		}
+	}
+	return args
+}
+
+// execInsert executes one insert statement of entries, in tx if it's not nil.
+// Method of config decides whether values are in text of statement, bound to prepared statement,
+// or loaded in bulk. Bulk load is always in tx.
+func (r *Runner) execInsert(routineId int, tx *sql.Tx, entries []*archive.Entry) error {
+	if insertMethod(r.cfg) == config.InsertMethodBulk {
+		return r.op.(operator.BulkLoader).BulkLoad(tx, r.cfg, entries)
+	}
+	if insertMethod(r.cfg) == config.InsertMethodText {
+		var err error
+		if tx != nil {
+			_, err = tx.Exec(r.op.BatchInsertSQL(r.cfg, entries))
+		} else {
+			_, err = r.dbs[routineId].Exec(r.op.BatchInsertSQL(r.cfg, entries))
+		}
+		return err
+	}
+	stmt, err := r.stmts[routineId].get(r, len(entries))
+	if err != nil {
+		return err
+	}
+	if tx != nil {
+		// Statement is prepared on the connection of tx if it's not yet.
+		stmt = tx.Stmt(stmt)
+	}
+	_, err = stmt.Exec(insertArgs(entries)...)
+	return err
+}
@@ -0,0 +1,100 @@
+package donkey
+
+import (
+	"donkey/pkg/report"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCheckFailed is returned by Check if data of testing database is not the same as written.
+var ErrCheckFailed = errors.New("check failed")
+
+// runResult collects phases and failures of a run for report.
+type runResult struct {
+	mu       sync.Mutex
+	start    time.Time
+	phases   []*report.Phase
+	failures []*report.Failure
+	// indeterminate is classified indeterminate writes of the last check.
+	indeterminate report.Indeterminate
+}
+
+func newRunResult() *runResult {
+	return &runResult{start: time.Now()}
+}
+
+// beginPhase records a phase began now, counts of phase are set by the phase itself.
+func (r *Runner) beginPhase(name string) *report.Phase {
+	phase := &report.Phase{Name: name, Start: time.Now(), Counts: make(map[string]uint64)}
+	r.result.mu.Lock()
+	r.result.phases = append(r.result.phases, phase)
+	r.result.mu.Unlock()
+	return phase
+}
+
+// endPhase records duration of phase and error returned by it, it's deferred with the named error of phase.
+func (r *Runner) endPhase(phase *report.Phase, err *error) {
+	r.result.mu.Lock()
+	defer r.result.mu.Unlock()
+	phase.Seconds = time.Since(phase.Start).Seconds()
+	if *err != nil {
+		phase.Error = (*err).Error()
+	}
+}
+
+// failure returns failures of class, it's created at the first time. Caller must hold the lock.
+func (r *Runner) failure(class string) *report.Failure {
+	for _, f := range r.result.failures {
+		if f.Class == class {
+			return f
+		}
+	}
+	f := &report.Failure{Class: class}
+	r.result.failures = append(r.result.failures, f)
+	return f
+}
+
+// addFailure records a failure of class without row id, e.g. a corrupted archive.
+func (r *Runner) addFailure(class string) {
+	r.metrics.checkFailures.With(class).Inc()
+	r.result.mu.Lock()
+	defer r.result.mu.Unlock()
+	r.failure(class).Count++
+}
+
+// addFailedIds records failed rows of class, report keeps at most report.MaxIds ids of a class.
+func (r *Runner) addFailedIds(class string, ids ...uint64) {
+	if len(ids) == 0 {
+		return
+	}
+	r.metrics.checkFailures.With(class).Add(uint64(len(ids)))
+	r.result.mu.Lock()
+	defer r.result.mu.Unlock()
+	f := r.failure(class)
+	f.Count += uint64(len(ids))
+	for _, id := range ids {
+		if len(f.Ids) >= report.MaxIds {
+			break
+		}
+		f.Ids = append(f.Ids, id)
+	}
+}
+
+// Report returns report of all phases run by runner, err is the error of the whole run.
+// A run is successful if there is no error and no verification failure.
+func (r *Runner) Report(err error) *report.Report {
+	r.result.mu.Lock()
+	defer r.result.mu.Unlock()
+	rep := report.New(r.cfg)
+	rep.Start = r.result.start
+	rep.End = time.Now()
+	rep.Phases = append(rep.Phases, r.result.phases...)
+	rep.Failures = append(rep.Failures, r.result.failures...)
+	rep.Indeterminate = r.result.indeterminate
+	if err != nil {
+		rep.Error = err.Error()
+	}
+	rep.Success = err == nil && len(rep.Failures) == 0
+	return rep
+}
@@ -0,0 +1,78 @@
+package donkey
+
+import (
+	"context"
+	"donkey/pkg/report"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunner_Report(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.Pass = "secret"
+	r := runDonkey(t, cfg)
+	rep := r.Report(nil)
+	if !rep.Success || len(rep.Failures) != 0 {
+		t.Errorf("Clean run should succeed, failures: %v", rep.Failures)
+	}
+	if len(rep.Phases) != 3 || rep.Phases[1].Name != "insert" || rep.Phases[1].Counts["inserted"] != cfg.InsertRows {
+		t.Errorf("Unexpected phases %+v", rep.Phases)
+	}
+	if rep.Config.Pass == cfg.Pass {
+		t.Error("Password should be masked in report")
+	}
+
+	if _, err := r.dbs[0].Exec("DELETE FROM donkey_test WHERE id IN (3, 7)"); err != nil {
+		t.Fatal("Delete rows failed, err:", err)
+	}
+	if err := r.Check(context.Background()); !errors.Is(err, ErrCheckFailed) {
+		t.Fatal("Check should fail with lost rows, err:", err)
+	}
+	rep = r.Report(ErrCheckFailed)
+	if rep.Success || len(rep.Failures) != 1 || rep.Failures[0].Class != failureData || rep.Failures[0].Count != 2 {
+		t.Fatalf("Unexpected failures %+v", rep.Failures)
+	}
+
+	jsonFile := filepath.Join(cfg.WorkDir, "report.json")
+	if err := rep.WriteJSON(jsonFile); err != nil {
+		t.Fatal("Write JSON report failed, err:", err)
+	}
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal("Read JSON report failed, err:", err)
+	}
+	decoded := &report.Report{}
+	if err = json.Unmarshal(data, decoded); err != nil {
+		t.Fatal("Decode JSON report failed, err:", err)
+	}
+	if decoded.Success || len(decoded.Phases) != 4 || decoded.Phases[3].Error != ErrCheckFailed.Error() {
+		t.Errorf("Unexpected JSON report %s", data)
+	}
+
+	junitFile := filepath.Join(cfg.WorkDir, "report.xml")
+	if err = rep.WriteJUnit(junitFile); err != nil {
+		t.Fatal("Write JUnit report failed, err:", err)
+	}
+	data, err = os.ReadFile(junitFile)
+	if err != nil {
+		t.Fatal("Read JUnit report failed, err:", err)
+	}
+	suites := struct {
+		Suites []struct {
+			Tests    int `xml:"tests,attr"`
+			Failures int `xml:"failures,attr"`
+			Errors   int `xml:"errors,attr"`
+		} `xml:"testsuite"`
+	}{}
+	if err = xml.Unmarshal(data, &suites); err != nil {
+		t.Fatal("Decode JUnit report failed, err:", err)
+	}
+	if len(suites.Suites) != 1 || suites.Suites[0].Tests != 5 ||
+		suites.Suites[0].Failures != 1 || suites.Suites[0].Errors != 1 {
+		t.Errorf("Unexpected JUnit report %s", data)
+	}
+}
@@ -0,0 +1,141 @@
+package donkey
+
+import (
+	"container/heap"
+	"context"
+	"donkey/pkg/archive"
+	"errors"
+	"fmt"
+
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+// reverseScanPageSize is the number of ids selected from testing table once.
+const reverseScanPageSize = 1000
+
+var (
+	ErrArchiveNotSorted = errors.New("ids in archive are not ascending")
+)
+
+// idStream reads ids of an archive in order.
+// Ids of one routine are always ascending, because the id counter only goes up.
+type idStream struct {
+	a        *archive.Archive
+	extraNum uint
+	id       uint64
+	started  bool
+}
+
+// next reads the next id, returns archive.ErrReadEndOfFile at the end of archive.
+func (s *idStream) next() error {
+	entry, err := s.a.GetOneEntry(s.extraNum)
+	for err == nil && entry.Op != archive.OpInsert {
+		// Update and delete are of inserted ids, only inserts are in order.
+		entry, err = s.a.GetOneEntry(s.extraNum)
+	}
+	if err != nil {
+		return err
+	}
+	if s.started && entry.Id <= s.id {
+		fmt.Printf("Archive of routine [%d] has id %d after %d\n", s.a.Id, entry.Id, s.id)
+		return ErrArchiveNotSorted
+	}
+	s.id = entry.Id
+	s.started = true
+	return nil
+}
+
+// idHeap merges ascending id streams of all archives.
+type idHeap []*idStream
+
+func (h idHeap) Len() int            { return len(h) }
+func (h idHeap) Less(i, j int) bool  { return h[i].id < h[j].id }
+func (h idHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *idHeap) Push(x interface{}) { *h = append(*h, x.(*idStream)) }
+func (h *idHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// pop removes the min id from merged streams.
+func (h *idHeap) pop() error {
+	s := (*h)[0]
+	err := s.next()
+	if err != nil {
+		if !errors.Is(err, archive.ErrReadEndOfFile) {
+			return err
+		}
+		heap.Pop(h)
+		return nil
+	}
+	heap.Fix(h, 0)
+	return nil
+}
+
+// reverseCheck scans testing table in id order, and reports every id which isn't in
+// any archive (or indeterminate archive) or unarchived ranges. Archives are merged as sorted streams,
+// so memory doesn't grow with number of rows.
+// It returns true if any row isn't accounted for.
+func (r *Runner) reverseCheck(ctx context.Context, unarchived idRanges) (bool, error) {
+	fmt.Println("Reverse checking...")
+	h := make(idHeap, 0, 2*len(r.archives))
+	for _, a := range append(append([]*archive.Archive{}, r.archives...), r.indeterminates...) {
+		a.SeekForRead()
+		s := &idStream{a: a, extraNum: r.cfg.ExtraColumnNum}
+		err := s.next()
+		if err != nil {
+			if errors.Is(err, archive.ErrReadEndOfFile) {
+				continue
+			}
+			fmt.Println("Reverse check read archive failed, err:", err)
+			return true, err
+		}
+		h = append(h, s)
+	}
+	heap.Init(&h)
+
+	scanSql := r.op.IdScanSQL(reverseScanPageSize)
+	unaccounted := uint64(0)
+	scanned := uint64(0)
+	nextId := uint64(0)
+	for ctx.Err() == nil {
+		ids := make([]uint64, 0, reverseScanPageSize)
+		err := r.dbs[0].SelectContext(ctx, &ids, scanSql, nextId)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			fmt.Println("Reverse check scan testing table failed, err:", err)
+			return true, err
+		}
+		for _, id := range ids {
+			// Ids only in archives are lost, they are reported by forward check.
+			for h.Len() > 0 && h[0].id < id {
+				if err = h.pop(); err != nil {
+					fmt.Println("Reverse check read archive failed, err:", err)
+					return true, err
+				}
+			}
+			if h.Len() > 0 && h[0].id == id || unarchived.contains(id) {
+				continue
+			}
+			unaccounted++
+			r.addFailedIds(failureReverse, id)
+			fmt.Printf("Reverse check failed: id %d in testing table isn't in any archive\n", id)
+			zlog.ErrorF("Reverse check failed: id [%d] in testing table isn't in any archive", id)
+		}
+		scanned += uint64(len(ids))
+		if len(ids) < reverseScanPageSize {
+			break
+		}
+		nextId = ids[len(ids)-1] + 1
+	}
+	if ctx.Err() != nil {
+		return true, ctx.Err()
+	}
+	fmt.Printf("Reverse check scanned %d rows, %d rows aren't in any archive\n", scanned, unaccounted)
+	zlog.InfoF("Reverse check scanned %d rows, %d rows aren't in any archive", scanned, unaccounted)
+	return unaccounted != 0, nil
+}
@@ -0,0 +1,232 @@
+package donkey
+
+import (
+	"context"
+	"donkey/pkg/config"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+// stage is a stage of schedule with values taken from config.
+type stage struct {
+	index int
+	// duration is 0 if stage runs until the end.
+	duration      time.Duration
+	routineNum    int
+	insertDelay   time.Duration
+	insertPackage uint
+	// rate is the target rate of rows per second, 0 is closed loop with insert delay.
+	rate  uint64
+	pacer *pacer
+}
+
+// startPacer starts timetable of stage from now.
+func (st *stage) startPacer() {
+	if st.rate != 0 {
+		st.pacer = newPacer(st.rate)
+	}
+}
+
+// begin returns the start time of an operation of rows in stage.
+// With rate, it waits for the intended start time of the operation and returns it.
+// It returns false if ctx is canceled.
+func (st *stage) begin(ctx context.Context, rows uint64) (time.Time, bool) {
+	if st.pacer == nil {
+		return time.Now(), ctx.Err() == nil
+	}
+	return st.pacer.wait(ctx, rows)
+}
+
+// scheduler runs stages of schedule in order, routines of pool are parked or woken up by stages.
+// Routine i runs only if i is less than routine number of the current stage,
+// so routines keep their connections and archives while the pool is resized.
+type scheduler struct {
+	stages []*stage
+	// limit is duration of config, 0 is unlimited.
+	limit time.Duration
+	// routineNum is the size of routine pool.
+	routineNum int
+	current    atomic.Value
+	mu         sync.Mutex
+	// changed is closed when stage is changed, parked routines wait on it.
+	changed chan struct{}
+	// finished is closed when there is nothing to insert, parked routines exit.
+	finished   chan struct{}
+	finishOnce sync.Once
+	// onStage is called when a stage begins.
+	onStage func(s *stage, total int)
+}
+
+// newScheduler returns scheduler of config, without schedule it has a stage of config running until the end.
+func newScheduler(cfg *config.Config, onStage func(s *stage, total int)) *scheduler {
+	schedule := cfg.Schedule
+	if len(schedule) == 0 {
+		schedule = config.Schedule{{}}
+	}
+	s := &scheduler{
+		limit:      time.Duration(cfg.Duration),
+		routineNum: int(cfg.RoutineNum),
+		changed:    make(chan struct{}),
+		finished:   make(chan struct{}),
+		onStage:    onStage,
+	}
+	for i, st := range schedule {
+		resolved := &stage{
+			index:         i,
+			duration:      time.Duration(st.Duration),
+			routineNum:    int(cfg.RoutineNum),
+			insertDelay:   time.Duration(cfg.InsertDelay) * time.Millisecond,
+			insertPackage: cfg.InsertPackage,
+			rate:          cfg.Rate,
+		}
+		if st.RoutineNum != 0 {
+			resolved.routineNum = int(st.RoutineNum)
+		}
+		if st.InsertDelay != nil {
+			resolved.insertDelay = time.Duration(*st.InsertDelay) * time.Millisecond
+		}
+		if st.InsertPackage != 0 {
+			resolved.insertPackage = st.InsertPackage
+		}
+		if st.Rate != 0 {
+			resolved.rate = st.Rate
+		}
+		// Operations start by timetable of rate, insert delay is ignored.
+		if resolved.rate != 0 {
+			resolved.insertDelay = 0
+		}
+		s.stages = append(s.stages, resolved)
+	}
+	s.stages[0].startPacer()
+	s.current.Store(s.stages[0])
+	return s
+}
+
+// stage returns the current stage.
+func (s *scheduler) stage() *stage {
+	return s.current.Load().(*stage)
+}
+
+// parks reports whether some routines are parked by a stage.
+func (s *scheduler) parks() bool {
+	for _, st := range s.stages {
+		if st.routineNum < s.routineNum {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *scheduler) enter(i int) {
+	s.stages[i].startPacer()
+	s.mu.Lock()
+	s.current.Store(s.stages[i])
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+	if s.onStage != nil {
+		s.onStage(s.stages[i], len(s.stages))
+	}
+}
+
+// wait blocks routine while it's parked by the current stage.
+// It returns false if routine should exit, because ctx is canceled or there is nothing to insert.
+func (s *scheduler) wait(ctx context.Context, routineId int) bool {
+	for {
+		s.mu.Lock()
+		running := routineId < s.stage().routineNum
+		changed := s.changed
+		s.mu.Unlock()
+		if running {
+			return ctx.Err() == nil
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-s.finished:
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// finish wakes up parked routines to exit, it's called when all rows are inserted.
+func (s *scheduler) finish() {
+	s.finishOnce.Do(func() {
+		close(s.finished)
+	})
+}
+
+// run runs stages until the last one or duration limit ends, then calls stop.
+// Stages are timed from the beginning, so they don't drift.
+func (s *scheduler) run(ctx context.Context, stop func()) {
+	begin := time.Now()
+	elapsed := time.Duration(0)
+	for i, st := range s.stages {
+		if i != 0 {
+			s.enter(i)
+		} else if s.onStage != nil {
+			s.onStage(st, len(s.stages))
+		}
+		if s.limit != 0 && (st.duration == 0 || elapsed+st.duration >= s.limit) {
+			sleepContext(ctx, time.Until(begin.Add(s.limit)))
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("Duration %s is reached, stop inserting\n", s.limit)
+			zlog.InfoF("Duration %s is reached, stop inserting", s.limit)
+			stop()
+			return
+		}
+		if st.duration == 0 {
+			return
+		}
+		elapsed += st.duration
+		sleepContext(ctx, time.Until(begin.Add(elapsed)))
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	fmt.Println("Schedule is finished, stop inserting")
+	zlog.InfoF("Schedule is finished, stop inserting")
+	stop()
+}
+
+// startSchedule runs schedule and duration limit of config for a phase.
+// The returned ctx is canceled when ctx is canceled, duration limit is reached or the last stage ends.
+// The returned function stops schedule, it must be called after routines exit.
+func (r *Runner) startSchedule(ctx context.Context) (*scheduler, context.Context, func()) {
+	sched := newScheduler(r.cfg, func(st *stage, total int) {
+		r.metrics.routines.Set(int64(st.routineNum))
+		if len(r.cfg.Schedule) == 0 {
+			return
+		}
+		pace := "insert delay " + st.insertDelay.String()
+		if st.rate != 0 {
+			pace = fmt.Sprintf("rate %d rows/s", st.rate)
+		}
+		duration := "until the end"
+		if st.duration != 0 {
+			duration = "for " + st.duration.String()
+		}
+		fmt.Printf("Schedule stage %d/%d: %d routines, %s, insert package %d, %s\n",
+			st.index+1, total, st.routineNum, pace, st.insertPackage, duration)
+		zlog.InfoF("Schedule stage %d/%d: %d routines, %s, insert package %d, %s",
+			st.index+1, total, st.routineNum, pace, st.insertPackage, duration)
+	})
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sched.run(ctx, cancel)
+	}()
+	return sched, ctx, func() {
+		cancel()
+		<-done
+		r.metrics.routines.Set(0)
+	}
+}
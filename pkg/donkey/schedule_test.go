@@ -0,0 +1,164 @@
+package donkey
+
+import (
+	"context"
+	"donkey/pkg/config"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	delay := int64(5)
+	cfg := &config.Config{
+		RoutineNum:    4,
+		InsertPackage: 1,
+		Schedule: config.Schedule{
+			{Duration: config.Duration(50 * time.Millisecond), RoutineNum: 1, InsertDelay: &delay},
+			{Duration: config.Duration(50 * time.Millisecond), InsertPackage: 3},
+		},
+	}
+	var stages []int
+	sched := newScheduler(cfg, func(st *stage, total int) {
+		stages = append(stages, st.index)
+	})
+	if st := sched.stage(); st.routineNum != 1 || st.insertDelay != 5*time.Millisecond || st.insertPackage != 1 {
+		t.Errorf("Unexpected first stage %+v", st)
+	}
+	if sched.stages[1].pacer != nil {
+		t.Error("Stage without rate should not have pacer")
+	}
+	if !sched.parks() {
+		t.Error("Scheduler with a stage of 1 routine should park routines")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	woken := make(chan time.Time)
+	go func() {
+		if sched.wait(ctx, 3) {
+			woken <- time.Now()
+		}
+	}()
+	begin := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sched.run(ctx, cancel)
+	}()
+	select {
+	case at := <-woken:
+		if at.Sub(begin) < 40*time.Millisecond {
+			t.Errorf("Parked routine is woken up after %s, expect the second stage", at.Sub(begin))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Parked routine isn't woken up by the second stage")
+	}
+	<-done
+	if ctx.Err() == nil {
+		t.Error("The end of schedule should stop inserting")
+	}
+	if len(stages) != 2 {
+		t.Errorf("Entered stages %v, expect [0 1]", stages)
+	}
+	if st := sched.stage(); st.routineNum != 4 || st.insertDelay != 0 || st.insertPackage != 3 {
+		t.Errorf("Unexpected second stage %+v", st)
+	}
+
+	// Stage with rate starts its timetable when it begins, insert delay is ignored.
+	sched = newScheduler(&config.Config{RoutineNum: 1, InsertDelay: 10, Rate: 100,
+		Schedule: config.Schedule{{Duration: config.Duration(time.Minute)}, {Rate: 200}}}, nil)
+	if st := sched.stage(); st.pacer == nil || st.rate != 100 || st.insertDelay != 0 {
+		t.Errorf("Unexpected stage with rate %+v", st)
+	}
+	sched.enter(1)
+	if st := sched.stage(); st.pacer == nil || st.rate != 200 {
+		t.Errorf("Unexpected second stage with rate %+v", st)
+	}
+
+	// Parked routine exits when there is nothing to insert.
+	sched = newScheduler(&config.Config{RoutineNum: 2, Schedule: config.Schedule{{RoutineNum: 1}}}, nil)
+	sched.finish()
+	if sched.wait(context.Background(), 1) {
+		t.Error("Parked routine should exit after finishing")
+	}
+}
+
+func TestRunner_Duration(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertRows = 0
+	cfg.Duration = config.Duration(200 * time.Millisecond)
+	begin := time.Now()
+	r := runDonkey(t, cfg)
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("Run with duration 200ms takes %s", elapsed)
+	}
+	if count := countRows(t, r); count == 0 {
+		t.Error("Nothing is inserted in duration")
+	}
+}
+
+func TestRunner_Schedule(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertRows = 0
+	cfg.Schedule = config.Schedule{
+		{Duration: config.Duration(100 * time.Millisecond), RoutineNum: 1},
+		{Duration: config.Duration(100 * time.Millisecond), InsertPackage: 5},
+	}
+	r := runDonkey(t, cfg)
+	// Parked routines are woken up by the second stage.
+	for _, a := range r.archives {
+		if a.EntryCount() == 0 {
+			t.Errorf("Routine %d inserts nothing", a.Id)
+		}
+	}
+
+	// Routines parked all the time exit when all rows are inserted.
+	cfg = sqliteConfig(t.TempDir())
+	cfg.Schedule = config.Schedule{{RoutineNum: 2}}
+	r = runDonkey(t, cfg)
+	if count := countRows(t, r); count != cfg.InsertRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows)
+	}
+	for _, a := range r.archives[2:] {
+		if a.EntryCount() != 0 {
+			t.Errorf("Parked routine %d inserts %d rows", a.Id, a.EntryCount())
+		}
+	}
+}
+
+func TestRunner_ScheduleRows(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertRows = 2000
+	delay := int64(2)
+	cfg.Schedule = config.Schedule{
+		{Duration: config.Duration(20 * time.Millisecond), RoutineNum: 1, InsertDelay: &delay, InsertPackage: 3},
+		{Duration: config.Duration(20 * time.Millisecond), InsertDelay: &delay, InsertPackage: 7},
+		{RoutineNum: 2, InsertPackage: 5},
+	}
+	r := runDonkey(t, cfg)
+	// Routines parked by stages don't keep ids, so the row limit is exact.
+	if count := countRows(t, r); count != cfg.InsertRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows)
+	}
+	total := uint64(0)
+	for _, a := range r.archives {
+		total += a.EntryCount()
+	}
+	if total != cfg.InsertRows {
+		t.Errorf("Archives have %d entries, expect %d", total, cfg.InsertRows)
+	}
+}
+
+func TestRunner_Rate(t *testing.T) {
+	cfg := sqliteConfig(t.TempDir())
+	cfg.InsertRows = 0
+	cfg.InsertPackage = 10
+	cfg.InsertDelay = 1000
+	cfg.Rate = 500
+	cfg.Duration = config.Duration(400 * time.Millisecond)
+	r := runDonkey(t, cfg)
+	// Rate is rows per second whatever insert package is, insert delay is ignored with rate.
+	if count := countRows(t, r); count < 100 || count > 300 {
+		t.Errorf("Testing table has %d rows, expect about 200 at rate 500 rows/s", count)
+	}
+}
@@ -0,0 +1,194 @@
+package donkey
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"donkey/pkg/archive"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	zlog "github.com/zhangyu0310/zlogger"
+)
+
+var (
+	ErrSeedRequired  = errors.New("seed is required to check rows without archive")
+	ErrDifferentSeed = errors.New("seed is different from manifest")
+)
+
+// generator derives values of rows from seed, so rows can be checked without archive.
+// Value of a column is HMAC-SHA256 of (id, version, column) keyed by seed, formatted as a UUID.
+type generator struct {
+	seed []byte
+}
+
+func newGenerator(seed string) *generator {
+	if seed == "" {
+		return nil
+	}
+	return &generator{seed: []byte(seed)}
+}
+
+// seedHash identifies seed in manifest without revealing it.
+func (g *generator) seedHash() string {
+	mac := hmac.New(sha256.New, g.seed)
+	mac.Write([]byte("donkey-seed"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func (g *generator) value(id uint64, version uint64, column int) string {
+	msg := make([]byte, 20)
+	binary.BigEndian.PutUint64(msg, id)
+	binary.BigEndian.PutUint64(msg[8:], version)
+	binary.BigEndian.PutUint32(msg[16:], uint32(column))
+	mac := hmac.New(sha256.New, g.seed)
+	mac.Write(msg)
+	var u uuid.UUID
+	copy(u[:], mac.Sum(nil))
+	// Version 8 is the UUID format for custom data.
+	u[6] = (u[6] & 0x0f) | 0x80
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u.String()
+}
+
+// fill sets values of entry by its id and version.
+func (g *generator) fill(entry *archive.Entry, extraNum uint) {
+	entry.Uuid = g.value(entry.Id, entry.Version, 0)
+	entry.ExtraUuid = make([]string, 0, extraNum)
+	for column := uint(0); column < extraNum; column++ {
+		entry.ExtraUuid = append(entry.ExtraUuid, g.value(entry.Id, entry.Version, int(column)+1))
+	}
+}
+
+// fillEntry sets values of entry, by seed or random.
+func (r *Runner) fillEntry(entry *archive.Entry) {
+	if r.gen != nil {
+		r.gen.fill(entry, r.cfg.ExtraColumnNum)
+		return
+	}
+	entry.Uuid = uuid.New().String()
+	if r.cfg.ExtraColumnNum != 0 {
+		entry.ExtraUuid = make([]string, 0, r.cfg.ExtraColumnNum)
+		for column := uint(0); column < r.cfg.ExtraColumnNum; column++ {
+			entry.ExtraUuid = append(entry.ExtraUuid, uuid.New().String())
+		}
+	}
+}
+
+// idRanges is ranges of ids inserted by runs without archive.
+type idRanges []IdRange
+
+// unarchivedRanges returns ids of finished runs without archive, except unknown ids.
+func unarchivedRanges(m *Manifest) idRanges {
+	var ranges idRanges
+	for _, run := range m.Runs {
+		if run.Archived || run.End == nil {
+			continue
+		}
+		first := run.FirstId
+		for _, unknown := range run.Unknown {
+			if unknown.First >= run.EndId {
+				break
+			}
+			if unknown.First > first {
+				ranges = append(ranges, IdRange{First: first, End: unknown.First})
+			}
+			if unknown.End > first {
+				first = unknown.End
+			}
+		}
+		if run.EndId > first {
+			ranges = append(ranges, IdRange{First: first, End: run.EndId})
+		}
+	}
+	return ranges
+}
+
+// contains reports whether id is in ranges, ranges must be sorted.
+func (ranges idRanges) contains(id uint64) bool {
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].End > id
+	})
+	return i < len(ranges) && ranges[i].First <= id
+}
+
+// checkSeed checks seed of config is the seed of every run without archive.
+func (r *Runner) checkSeed(m *Manifest) error {
+	for _, run := range m.Runs {
+		if run.Archived {
+			continue
+		}
+		if r.gen == nil {
+			fmt.Printf("Run %s isn't archived, seed is required\n", run.RunId)
+			return ErrSeedRequired
+		}
+		if run.SeedHash != r.gen.seedHash() {
+			fmt.Printf("Run %s is inserted with another seed\n", run.RunId)
+			return ErrDifferentSeed
+		}
+	}
+	return nil
+}
+
+// checkUnarchivedRuns checks rows of runs without archive, their values are derived from seed.
+// Routines check batches of ids in turn. It returns true if any row is failed.
+func (r *Runner) checkUnarchivedRuns(ctx context.Context, m *Manifest, batch int) (bool, error) {
+	err := r.checkSeed(m)
+	if err != nil {
+		return true, err
+	}
+	for _, run := range m.Runs {
+		if !run.Archived && run.End == nil {
+			fmt.Printf("Run %s without archive isn't finished, its rows can't be checked\n", run.RunId)
+			zlog.WarnF("Run %s without archive isn't finished, its rows can't be checked", run.RunId)
+		}
+	}
+	ranges := unarchivedRanges(m)
+	if len(ranges) == 0 {
+		return false, nil
+	}
+	fmt.Println("Checking rows without archive...")
+	// Batches of all ranges, routine i checks batch i, i + routine num ...
+	var batches idRanges
+	for _, idRange := range ranges {
+		for first := idRange.First; first < idRange.End; first += uint64(batch) {
+			end := first + uint64(batch)
+			if end > idRange.End {
+				end = idRange.End
+			}
+			batches = append(batches, IdRange{First: first, End: end})
+		}
+	}
+	failedNum := uint64(0)
+	wg := sync.WaitGroup{}
+	wg.Add(int(r.cfg.RoutineNum))
+	for i := 0; i < int(r.cfg.RoutineNum); i++ {
+		go func(routineId int) {
+			defer wg.Done()
+			for b := routineId; b < len(batches) && ctx.Err() == nil; b += int(r.cfg.RoutineNum) {
+				entries := make([]*archive.Entry, 0, batch)
+				for id := batches[b].First; id < batches[b].End; id++ {
+					entry := &archive.Entry{Id: id}
+					r.gen.fill(entry, r.cfg.ExtraColumnNum)
+					entries = append(entries, entry)
+				}
+				var failedIds []uint64
+				if batch == 1 {
+					failedIds = r.checkEntriesOneByOne(ctx, routineId, entries)
+				} else {
+					failedIds = r.checkEntriesInBatch(ctx, routineId, entries)
+				}
+				atomic.AddUint64(&failedNum, uint64(len(failedIds)))
+				r.addFailedIds(failureData, failedIds...)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return failedNum != 0, ctx.Err()
+}
@@ -0,0 +1,85 @@
+package donkey
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGenerator_Value(t *testing.T) {
+	g := newGenerator("donkey")
+	v := g.value(42, 0, 1)
+	if _, err := uuid.Parse(v); err != nil {
+		t.Errorf("Value %s isn't a UUID, err: %s", v, err)
+	}
+	if newGenerator("donkey").value(42, 0, 1) != v {
+		t.Error("Same seed should derive the same value")
+	}
+	for _, other := range []string{
+		newGenerator("mule").value(42, 0, 1),
+		g.value(43, 0, 1),
+		g.value(42, 1, 1),
+		g.value(42, 0, 2),
+	} {
+		if other == v {
+			t.Errorf("Value %s should be different", other)
+		}
+	}
+	if newGenerator("") != nil {
+		t.Error("Empty seed should be no generator")
+	}
+}
+
+func TestRunner_Unarchived(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t.TempDir())
+	cfg.Seed = "donkey"
+	cfg.ArchiveData = false
+	cfg.ReverseCheck = true
+	r := runDonkey(t, cfg)
+
+	if count := countRows(t, r); count != cfg.InsertRows {
+		t.Errorf("Testing table has %d rows, expect %d", count, cfg.InsertRows)
+	}
+	m, err := r.readManifest(false)
+	if err != nil {
+		t.Fatal("Read manifest failed, err:", err)
+	}
+	if m.TotalEntries() != 0 {
+		t.Errorf("Archives have %d entries, expect none", m.TotalEntries())
+	}
+	run := m.Runs[0]
+	if run.Archived || run.Inserted != cfg.InsertRows || run.EndId-run.FirstId != cfg.InsertRows {
+		t.Errorf("Run without archive isn't recorded correctly: %+v", run)
+	}
+	failed, err := r.checkUnarchivedRuns(ctx, m, 100)
+	if err != nil || failed {
+		t.Errorf("Rows derived from seed should pass check, failed: %v, err: %v", failed, err)
+	}
+	failed, err = r.reverseCheck(ctx, unarchivedRanges(m))
+	if err != nil || failed {
+		t.Errorf("All rows are in unarchived ranges, failed: %v, err: %v", failed, err)
+	}
+
+	if _, err = r.dbs[0].Exec("UPDATE donkey_test SET uuid = 'tampered' WHERE id = 500"); err != nil {
+		t.Fatal("Update row failed, err:", err)
+	}
+	failed, err = r.checkUnarchivedRuns(ctx, m, 1)
+	if err != nil || !failed {
+		t.Errorf("Tampered row should fail check, failed: %v, err: %v", failed, err)
+	}
+
+	r.Close()
+	cfg.Seed = "mule"
+	r = newRunner(t, cfg)
+	if err = r.Insert(ctx); !errors.Is(err, ErrDifferentSeed) {
+		t.Error("Insert should fail with another seed, err:", err)
+	}
+	cfg.Seed = ""
+	r = newRunner(t, cfg)
+	if err = r.Check(ctx); !errors.Is(err, ErrSeedRequired) {
+		t.Error("Check should fail without seed, err:", err)
+	}
+}
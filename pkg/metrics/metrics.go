@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"bufio"
+	"donkey/pkg/stats"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are upper bounds (seconds) of latency histograms.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Counter is a value which only goes up.
+type Counter struct {
+	v uint64
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+// Gauge is a value which goes up and down.
+type Gauge struct {
+	v int64
+}
+
+func (g *Gauge) Set(v int64) {
+	atomic.StoreInt64(&g.v, v)
+}
+
+func (g *Gauge) Add(n int64) {
+	atomic.AddInt64(&g.v, n)
+}
+
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.v)
+}
+
+// CounterVec is counters of a label, e.g. errors by class.
+type CounterVec struct {
+	label    string
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// With returns counter of label value, it's created at the first time.
+func (v *CounterVec) With(value string) *Counter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[value]
+	if !ok {
+		c = &Counter{}
+		v.counters[value] = c
+	}
+	return c
+}
+
+// metric is a registered metric, write writes its samples in text format.
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	write func(w io.Writer, name string)
+}
+
+// Registry is metrics exposed in Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name, help, kind string, write func(w io.Writer, name string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, &metric{name: name, help: help, kind: kind, write: write})
+}
+
+// Counter registers a counter.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", func(w io.Writer, name string) {
+		_, _ = fmt.Fprintf(w, "%s %d\n", name, c.Value())
+	})
+	return c
+}
+
+// CounterVec registers counters of a label.
+func (r *Registry) CounterVec(name, help, label string) *CounterVec {
+	v := &CounterVec{label: label, counters: make(map[string]*Counter)}
+	r.register(name, help, "counter", func(w io.Writer, name string) {
+		v.mu.Lock()
+		values := make([]string, 0, len(v.counters))
+		for value := range v.counters {
+			values = append(values, value)
+		}
+		v.mu.Unlock()
+		sort.Strings(values)
+		for _, value := range values {
+			_, _ = fmt.Fprintf(w, "%s{%s=%s} %d\n", name, label, quote(value), v.With(value).Value())
+		}
+	})
+	return v
+}
+
+// Gauge registers a gauge.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", func(w io.Writer, name string) {
+		_, _ = fmt.Fprintf(w, "%s %d\n", name, g.Value())
+	})
+	return g
+}
+
+// Latency registers latency histograms of all operations of recorder,
+// labeled by operation and result (ok or error).
+func (r *Registry) Latency(name, help string, recorder *stats.Recorder) {
+	r.register(name, help, "histogram", func(w io.Writer, name string) {
+		for _, s := range recorder.Total() {
+			writeHistogram(w, name, fmt.Sprintf("op=%s,result=\"ok\"", quote(s.Op)), s.Ok)
+			writeHistogram(w, name, fmt.Sprintf("op=%s,result=\"error\"", quote(s.Op)), s.Err)
+		}
+	})
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *stats.Histogram) {
+	counts := h.CountBelow(LatencyBuckets)
+	for i, bound := range LatencyBuckets {
+		_, _ = fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels,
+			strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), counts[i])
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count())
+	_, _ = fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels,
+		strconv.FormatFloat(h.Sum().Seconds(), 'g', -1, 64))
+	_, _ = fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.Count())
+}
+
+// quote quotes label value of text format.
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// Write writes all metrics in Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]*metric{}, r.metrics...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		m.write(bw, m.name)
+	}
+	return bw.Flush()
+}
+
+// Handler returns HTTP handler of /metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.Write(w)
+	})
+}
+
+// Serve listens on addr and serves /metrics of registry in background.
+// Addr of the returned server is the listening address, close it to stop serving.
+func Serve(addr string, r *Registry) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	server := &http.Server{Addr: listener.Addr().String(), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return server, nil
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"bytes"
+	"donkey/pkg/stats"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("rows_total", "Rows.").Add(3)
+	r.Gauge("routines", "Routines.").Set(4)
+	errs := r.CounterVec("errors_total", "Errors.", "class")
+	errs.With("lost").Inc()
+	errs.With(`say "hi"`).Add(2)
+	recorder := stats.NewRecorder()
+	recorder.Record("insert", time.Millisecond, true)
+	recorder.Record("insert", time.Second, true)
+	r.Latency("latency_seconds", "Latency.", recorder)
+
+	buf := bytes.Buffer{}
+	if err := r.Write(&buf); err != nil {
+		t.Fatal("Write metrics failed, err:", err)
+	}
+	for _, line := range []string{
+		"# TYPE rows_total counter",
+		"rows_total 3",
+		"routines 4",
+		`errors_total{class="lost"} 1`,
+		`errors_total{class="say \"hi\""} 2`,
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{op="insert",result="ok",le="0.0025"} 1`,
+		`latency_seconds_bucket{op="insert",result="ok",le="+Inf"} 2`,
+		`latency_seconds_count{op="insert",result="error"} 0`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Metrics don't have line %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("rows_total", "Rows.").Inc()
+	server, err := Serve("127.0.0.1:0", r)
+	if err != nil {
+		t.Fatal("Serve metrics failed, err:", err)
+	}
+	defer server.Close()
+	resp, err := http.Get("http://" + server.Addr + "/metrics")
+	if err != nil {
+		t.Fatal("Get metrics failed, err:", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "rows_total 1\n") {
+		t.Errorf("Metrics response is unexpected:\n%s", body)
+	}
+}
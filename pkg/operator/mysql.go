@@ -1,11 +1,16 @@
 package operator
 
 import (
+	"database/sql"
+	"donkey/pkg/archive"
 	"donkey/pkg/config"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"sync/atomic"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -19,8 +24,24 @@ type MySQLVariable struct {
 	Value string
 }
 
-func CreateDbForMySQL(db *sqlx.DB) error {
-	cfg := config.GetGlobalConfig()
+// MySQL is the operator of MySQL compatible databases.
+type MySQL struct{}
+
+func init() {
+	Register(&MySQL{})
+}
+
+func (m *MySQL) Name() string {
+	return "mysql"
+}
+
+func (m *MySQL) Connect(cfg *config.Config, dbName string) (*sqlx.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8",
+		cfg.User, cfg.Pass, cfg.Host, cfg.Port, dbName)
+	return sqlx.Open("mysql", dsn)
+}
+
+func (m *MySQL) CreateDatabase(db *sqlx.DB, cfg *config.Config) error {
 	var lowerCase bool
 	variable := MySQLVariable{}
 	err := db.QueryRow("SHOW VARIABLES LIKE 'lower_case_table_names'").Scan(&variable.Name, &variable.Value)
@@ -69,7 +90,7 @@ func CreateDbForMySQL(db *sqlx.DB) error {
 		}
 	}
 	if !existDb {
-		sql := fmt.Sprintf("CREATE DATABASE %s", cfg.Database)
+		sql := fmt.Sprintf("CREATE DATABASE %s", m.QuoteIdentifier(cfg.Database))
 		_, err = db.Exec(sql)
 		if err != nil {
 			fmt.Println("MySQL create database failed, err:", err)
@@ -81,8 +102,20 @@ func CreateDbForMySQL(db *sqlx.DB) error {
 	return nil
 }
 
-func CreateTableForMySQL(db *sqlx.DB) error {
-	cfg := config.GetGlobalConfig()
+func (m *MySQL) DropDatabase(db *sqlx.DB, cfg *config.Config) error {
+	_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", m.QuoteIdentifier(cfg.Database)))
+	if err != nil {
+		fmt.Println("MySQL drop database failed, err:", err)
+		return err
+	}
+	return nil
+}
+
+func (m *MySQL) DropTableSQL(table string) string {
+	return buildDropTableSQL(m, table)
+}
+
+func (m *MySQL) CreateTable(db *sqlx.DB, cfg *config.Config) error {
 	rows, err := db.Query("SHOW TABLES")
 	if err != nil {
 		fmt.Println("MySQL get tables failed, err:", err)
@@ -138,3 +171,100 @@ func CreateTableForMySQL(db *sqlx.DB) error {
 	}
 	return nil
 }
+
+func (m *MySQL) BatchInsertSQL(cfg *config.Config, entries []*archive.Entry) string {
+	return buildBatchInsertSQL(m, cfg, entries)
+}
+
+func (m *MySQL) PreparedInsertSQL(cfg *config.Config, n int) string {
+	return buildPreparedInsertSQL(m, cfg, n)
+}
+
+func (m *MySQL) PointLookupSQL() string {
+	return buildPointLookupSQL(m)
+}
+
+func (m *MySQL) BatchLookupSQL(n int) string {
+	return buildBatchLookupSQL(m, n)
+}
+
+func (m *MySQL) UpdateSQL(cfg *config.Config) string {
+	return buildUpdateSQL(m, cfg)
+}
+
+func (m *MySQL) DeleteSQL() string {
+	return buildDeleteSQL(m)
+}
+
+func (m *MySQL) MaxIdSQL() string {
+	return buildMaxIdSQL(m)
+}
+
+func (m *MySQL) IdScanSQL(limit int) string {
+	return buildIdScanSQL(m, limit)
+}
+
+func (m *MySQL) CreateAccountsSQL() string {
+	return buildCreateAccountsSQL(m)
+}
+
+func (m *MySQL) InsertAccountsSQL(first uint64, n uint64, balance int64) string {
+	return buildInsertAccountsSQL(m, first, n, balance)
+}
+
+func (m *MySQL) AddBalanceSQL() string {
+	return buildAddBalanceSQL(m)
+}
+
+func (m *MySQL) TotalBalanceSQL() string {
+	return buildTotalBalanceSQL(m)
+}
+
+// loadReaderSeq makes names of reader handlers unique, every load registers its own handler.
+var loadReaderSeq uint64
+
+// BulkLoad loads entries by LOAD DATA LOCAL INFILE from a reader, server must enable local_infile.
+// Rows are tab separated, uuid values don't need escaping.
+// Duplicate keys are ignored by LOCAL loading, so number of loaded rows is checked.
+func (m *MySQL) BulkLoad(tx *sql.Tx, cfg *config.Config, entries []*archive.Entry) error {
+	data := strings.Builder{}
+	for _, entry := range entries {
+		data.WriteString(fmt.Sprintf("%d\t%s", entry.Id, entry.Uuid))
+		for _, extra := range entry.ExtraUuid {
+			data.WriteString("\t" + extra)
+		}
+		data.WriteString("\n")
+	}
+	name := fmt.Sprintf("donkey_%d", atomic.AddUint64(&loadReaderSeq, 1))
+	mysql.RegisterReaderHandler(name, func() io.Reader {
+		return strings.NewReader(data.String())
+	})
+	defer mysql.DeregisterReaderHandler(name)
+
+	columns := ColumnNames(cfg.ExtraColumnNum)
+	for i := range columns {
+		columns[i] = m.QuoteIdentifier(columns[i])
+	}
+	result, err := tx.Exec(fmt.Sprintf("LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s "+
+		"FIELDS TERMINATED BY '\\t' LINES TERMINATED BY '\\n' (%s)",
+		name, m.QuoteIdentifier(TestingTable), strings.Join(columns, ", ")))
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != int64(len(entries)) {
+		return fmt.Errorf("%w: %d of %d rows", ErrBulkLoadIncomplete, affected, len(entries))
+	}
+	return nil
+}
+
+func (m *MySQL) QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (m *MySQL) Placeholder(_ int) string {
+	return "?"
+}
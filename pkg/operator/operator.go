@@ -0,0 +1,266 @@
+package operator
+
+import (
+	"database/sql"
+	"donkey/pkg/archive"
+	"donkey/pkg/config"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrUnknownDbType        = errors.New("unknown db type")
+	ErrUnsupportedIsolation = errors.New("unsupported isolation level")
+	ErrBulkLoadNotSupported = errors.New("bulk load is not supported")
+	ErrBulkLoadIncomplete   = errors.New("bulk load wrote different number of rows")
+)
+
+// TestingTable is the name of testing table.
+const TestingTable = "donkey_test"
+
+// AccountsTable is the name of accounts table of bank workload.
+const AccountsTable = "donkey_accounts"
+
+// Operator is the backend of a kind of testing database.
+// A new backend implements Operator in its own file and registers itself in init().
+type Operator interface {
+	// Name returns the db type of operator, it's the value of -db-type.
+	Name() string
+	// Connect opens a connection pool to testing database.
+	// Empty dbName means connecting without choosing a database.
+	Connect(cfg *config.Config, dbName string) (*sqlx.DB, error)
+	// CreateDatabase creates testing database if it's not exist.
+	CreateDatabase(db *sqlx.DB, cfg *config.Config) error
+	// CreateTable creates testing table if it's not exist, otherwise validates it.
+	CreateTable(db *sqlx.DB, cfg *config.Config) error
+	// DropDatabase drops testing database if it's exist. Db isn't connected to testing database.
+	DropDatabase(db *sqlx.DB, cfg *config.Config) error
+	// DropTableSQL returns the SQL dropping table if it's exist.
+	DropTableSQL(table string) string
+	// BatchInsertSQL returns the SQL inserting all entries in one statement.
+	BatchInsertSQL(cfg *config.Config, entries []*archive.Entry) string
+	// PreparedInsertSQL returns the SQL inserting n rows in one statement.
+	// Bind variables are columns of every row in order.
+	PreparedInsertSQL(cfg *config.Config, n int) string
+	// PointLookupSQL returns the SQL selecting one row by id. Id is the only bind variable.
+	PointLookupSQL() string
+	// BatchLookupSQL returns the SQL selecting rows of n ids. Ids are the n bind variables.
+	BatchLookupSQL(n int) string
+	// UpdateSQL returns the SQL updating all uuid columns of a row.
+	// Bind variables are uuid columns in order, then id.
+	UpdateSQL(cfg *config.Config) string
+	// DeleteSQL returns the SQL deleting a row by id. Id is the only bind variable.
+	DeleteSQL() string
+	// MaxIdSQL returns the SQL selecting max id of testing table.
+	MaxIdSQL() string
+	// IdScanSQL returns the SQL selecting at most limit ids in order,
+	// which are not less than the only bind variable.
+	IdScanSQL(limit int) string
+	// CreateAccountsSQL returns the SQL creating accounts table if it's not exist.
+	CreateAccountsSQL() string
+	// InsertAccountsSQL returns the SQL inserting n accounts from id first with balance.
+	InsertAccountsSQL(first uint64, n uint64, balance int64) string
+	// AddBalanceSQL returns the SQL adding amount to balance of an account.
+	// Bind variables are amount and id.
+	AddBalanceSQL() string
+	// TotalBalanceSQL returns the SQL selecting number of accounts and sum of their balance.
+	TotalBalanceSQL() string
+	// QuoteIdentifier quotes table or column name.
+	QuoteIdentifier(name string) string
+	// Placeholder returns the n-th (start from 1) bind variable.
+	Placeholder(n int) string
+}
+
+// IsolationConverter is implemented by operators whose driver can't use every isolation level.
+// It converts level of config to the level for driver, or returns ErrUnsupportedIsolation.
+type IsolationConverter interface {
+	ConvertIsolation(level sql.IsolationLevel) (sql.IsolationLevel, error)
+}
+
+// TxOptions returns options beginning a transaction of isolation level with operator.
+func TxOptions(op Operator, level sql.IsolationLevel) (*sql.TxOptions, error) {
+	if converter, ok := op.(IsolationConverter); ok {
+		var err error
+		level, err = converter.ConvertIsolation(level)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &sql.TxOptions{Isolation: level}, nil
+}
+
+// BulkLoader is implemented by operators which can load rows in bulk, e.g. LOAD DATA or COPY.
+type BulkLoader interface {
+	// BulkLoad loads entries into testing table in tx, they are written only if tx is committed.
+	BulkLoad(tx *sql.Tx, cfg *config.Config, entries []*archive.Entry) error
+}
+
+var operators = make(map[string]Operator)
+
+func init() {
+	config.CheckDbType = func(dbType string) error {
+		if _, err := GetOperator(dbType); err != nil {
+			return fmt.Errorf("%w %q, it isn't one of %s", err, dbType, strings.Join(Names(), ", "))
+		}
+		return nil
+	}
+}
+
+// Register makes an operator available by its name.
+// It should be called in init(), and panics if the name is registered twice.
+func Register(op Operator) {
+	name := strings.ToLower(op.Name())
+	if _, ok := operators[name]; ok {
+		panic("operator: Register called twice for " + name)
+	}
+	operators[name] = op
+}
+
+// GetOperator returns the operator of db type.
+func GetOperator(dbType string) (Operator, error) {
+	op, ok := operators[strings.ToLower(dbType)]
+	if !ok {
+		return nil, ErrUnknownDbType
+	}
+	return op, nil
+}
+
+// Names returns sorted names of all registered operators.
+func Names() []string {
+	names := make([]string, 0, len(operators))
+	for name := range operators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ColumnNames returns column names of testing table.
+func ColumnNames(extraColumnNum uint) []string {
+	columns := make([]string, 0, extraColumnNum+2)
+	columns = append(columns, "id", "uuid")
+	for i := uint(0); i < extraColumnNum; i++ {
+		columns = append(columns, fmt.Sprintf("uuid_extra_%d", i))
+	}
+	return columns
+}
+
+func buildBatchInsertSQL(op Operator, cfg *config.Config, entries []*archive.Entry) string {
+	columns := ColumnNames(cfg.ExtraColumnNum)
+	for i := range columns {
+		columns[i] = op.QuoteIdentifier(columns[i])
+	}
+	s := strings.Builder{}
+	s.WriteString(fmt.Sprintf("INSERT INTO %s (%s) VALUES ",
+		op.QuoteIdentifier(TestingTable), strings.Join(columns, ", ")))
+	for i, entry := range entries {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(fmt.Sprintf("('%d', '%s'", entry.Id, entry.Uuid))
+		for _, extra := range entry.ExtraUuid {
+			s.WriteString(fmt.Sprintf(", '%s'", extra))
+		}
+		s.WriteString(")")
+	}
+	return s.String()
+}
+
+func buildPreparedInsertSQL(op Operator, cfg *config.Config, n int) string {
+	columns := ColumnNames(cfg.ExtraColumnNum)
+	for i := range columns {
+		columns[i] = op.QuoteIdentifier(columns[i])
+	}
+	s := strings.Builder{}
+	s.WriteString(fmt.Sprintf("INSERT INTO %s (%s) VALUES ",
+		op.QuoteIdentifier(TestingTable), strings.Join(columns, ", ")))
+	placeholders := make([]string, len(columns))
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		for column := range columns {
+			placeholders[column] = op.Placeholder(i*len(columns) + column + 1)
+		}
+		s.WriteString("(" + strings.Join(placeholders, ", ") + ")")
+	}
+	return s.String()
+}
+
+func buildPointLookupSQL(op Operator) string {
+	return fmt.Sprintf("SELECT * FROM %s WHERE %s=%s",
+		op.QuoteIdentifier(TestingTable), op.QuoteIdentifier("id"), op.Placeholder(1))
+}
+
+func buildBatchLookupSQL(op Operator, n int) string {
+	placeholders := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		placeholders = append(placeholders, op.Placeholder(i))
+	}
+	return fmt.Sprintf("SELECT * FROM %s WHERE %s IN (%s)",
+		op.QuoteIdentifier(TestingTable), op.QuoteIdentifier("id"), strings.Join(placeholders, ", "))
+}
+
+func buildUpdateSQL(op Operator, cfg *config.Config) string {
+	columns := ColumnNames(cfg.ExtraColumnNum)[1:]
+	sets := make([]string, 0, len(columns))
+	for i, column := range columns {
+		sets = append(sets, fmt.Sprintf("%s=%s", op.QuoteIdentifier(column), op.Placeholder(i+1)))
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s=%s", op.QuoteIdentifier(TestingTable),
+		strings.Join(sets, ", "), op.QuoteIdentifier("id"), op.Placeholder(len(columns)+1))
+}
+
+func buildDeleteSQL(op Operator) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s=%s",
+		op.QuoteIdentifier(TestingTable), op.QuoteIdentifier("id"), op.Placeholder(1))
+}
+
+func buildDropTableSQL(op Operator, table string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s", op.QuoteIdentifier(table))
+}
+
+func buildCreateAccountsSQL(op Operator) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s BIGINT NOT NULL, %s BIGINT NOT NULL, PRIMARY KEY (%s))",
+		op.QuoteIdentifier(AccountsTable), op.QuoteIdentifier("id"), op.QuoteIdentifier("balance"),
+		op.QuoteIdentifier("id"))
+}
+
+func buildInsertAccountsSQL(op Operator, first uint64, n uint64, balance int64) string {
+	s := strings.Builder{}
+	s.WriteString(fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES ", op.QuoteIdentifier(AccountsTable),
+		op.QuoteIdentifier("id"), op.QuoteIdentifier("balance")))
+	for i := uint64(0); i < n; i++ {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(fmt.Sprintf("(%d, %d)", first+i, balance))
+	}
+	return s.String()
+}
+
+func buildAddBalanceSQL(op Operator) string {
+	return fmt.Sprintf("UPDATE %s SET %s=%s+%s WHERE %s=%s", op.QuoteIdentifier(AccountsTable),
+		op.QuoteIdentifier("balance"), op.QuoteIdentifier("balance"), op.Placeholder(1),
+		op.QuoteIdentifier("id"), op.Placeholder(2))
+}
+
+func buildTotalBalanceSQL(op Operator) string {
+	return fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(%s), 0) FROM %s",
+		op.QuoteIdentifier("balance"), op.QuoteIdentifier(AccountsTable))
+}
+
+func buildMaxIdSQL(op Operator) string {
+	return fmt.Sprintf("SELECT %s FROM %s ORDER BY %s DESC LIMIT 1",
+		op.QuoteIdentifier("id"), op.QuoteIdentifier(TestingTable), op.QuoteIdentifier("id"))
+}
+
+func buildIdScanSQL(op Operator, limit int) string {
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s>=%s ORDER BY %s LIMIT %d",
+		op.QuoteIdentifier("id"), op.QuoteIdentifier(TestingTable), op.QuoteIdentifier("id"),
+		op.Placeholder(1), op.QuoteIdentifier("id"), limit)
+}
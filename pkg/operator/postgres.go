@@ -0,0 +1,198 @@
+package operator
+
+import (
+	"database/sql"
+	"donkey/pkg/archive"
+	"donkey/pkg/config"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Postgres is the operator of PostgreSQL compatible databases.
+type Postgres struct{}
+
+func init() {
+	Register(&Postgres{})
+}
+
+func (p *Postgres) Name() string {
+	return "postgres"
+}
+
+func (p *Postgres) Connect(cfg *config.Config, dbName string) (*sqlx.DB, error) {
+	// Postgres can't connect without database, use the default one.
+	if dbName == "" {
+		dbName = "postgres"
+	}
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		quotePgDsnValue(cfg.Host), cfg.Port, quotePgDsnValue(cfg.User),
+		quotePgDsnValue(cfg.Pass), quotePgDsnValue(dbName))
+	return sqlx.Open("postgres", dsn)
+}
+
+// quotePgDsnValue quotes value of postgres key/value connection string.
+func quotePgDsnValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+func (p *Postgres) CreateDatabase(db *sqlx.DB, cfg *config.Config) error {
+	existDb := false
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1)",
+		cfg.Database).Scan(&existDb)
+	if err != nil {
+		fmt.Println("Postgres get databases failed, err:", err)
+		return err
+	}
+	if !existDb {
+		// Database name is quoted, so it keeps the same case as config.
+		sql := fmt.Sprintf("CREATE DATABASE %s", p.QuoteIdentifier(cfg.Database))
+		_, err = db.Exec(sql)
+		if err != nil {
+			fmt.Println("Postgres create database failed, err:", err)
+			return err
+		}
+	} else {
+		fmt.Printf("Database %s is exist, don't need to create again.\n", cfg.Database)
+	}
+	return nil
+}
+
+func (p *Postgres) DropDatabase(db *sqlx.DB, cfg *config.Config) error {
+	_, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", p.QuoteIdentifier(cfg.Database)))
+	if err != nil {
+		fmt.Println("Postgres drop database failed, err:", err)
+		return err
+	}
+	return nil
+}
+
+func (p *Postgres) DropTableSQL(table string) string {
+	return buildDropTableSQL(p, table)
+}
+
+func (p *Postgres) CreateTable(db *sqlx.DB, cfg *config.Config) error {
+	existTable := false
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_tables " +
+		"WHERE schemaname = current_schema() AND tablename = 'donkey_test')").Scan(&existTable)
+	if err != nil {
+		fmt.Println("Postgres get tables failed, err:", err)
+		return err
+	}
+
+	if !existTable {
+		s := `CREATE TABLE IF NOT EXISTS "donkey_test" (` +
+			`"id" BIGINT NOT NULL,` +
+			`"uuid" CHAR(36) NOT NULL,`
+		for i := uint(0); i < cfg.ExtraColumnNum; i++ {
+			s += fmt.Sprintf(`"uuid_extra_%d" CHAR(36) NOT NULL,`, i)
+		}
+		s += `PRIMARY KEY ("id")` +
+			`) %s`
+		sql := fmt.Sprintf(s, cfg.UniqueSyntax)
+		_, err := db.Exec(sql)
+		if err != nil {
+			fmt.Println("Postgres create table failed, err:", err)
+			return err
+		}
+	} else {
+		// Check extra column num
+		columnCount := uint(0)
+		err := db.QueryRow("SELECT COUNT(*) FROM pg_catalog.pg_attribute " +
+			"WHERE attrelid = 'donkey_test'::regclass AND attnum > 0 AND NOT attisdropped").Scan(&columnCount)
+		if err != nil {
+			fmt.Println("Postgres check table column failed, err:", err)
+			return err
+		}
+		if columnCount != cfg.ExtraColumnNum+2 {
+			fmt.Printf("Extra column number is different. Testing table is [%d], config is [%d]\n",
+				columnCount-2, cfg.ExtraColumnNum)
+			return ErrDifferentColumnNum
+		}
+	}
+	return nil
+}
+
+func (p *Postgres) BatchInsertSQL(cfg *config.Config, entries []*archive.Entry) string {
+	return buildBatchInsertSQL(p, cfg, entries)
+}
+
+func (p *Postgres) PreparedInsertSQL(cfg *config.Config, n int) string {
+	return buildPreparedInsertSQL(p, cfg, n)
+}
+
+func (p *Postgres) PointLookupSQL() string {
+	return buildPointLookupSQL(p)
+}
+
+func (p *Postgres) BatchLookupSQL(n int) string {
+	return buildBatchLookupSQL(p, n)
+}
+
+func (p *Postgres) UpdateSQL(cfg *config.Config) string {
+	return buildUpdateSQL(p, cfg)
+}
+
+func (p *Postgres) DeleteSQL() string {
+	return buildDeleteSQL(p)
+}
+
+func (p *Postgres) MaxIdSQL() string {
+	return buildMaxIdSQL(p)
+}
+
+func (p *Postgres) IdScanSQL(limit int) string {
+	return buildIdScanSQL(p, limit)
+}
+
+func (p *Postgres) CreateAccountsSQL() string {
+	return buildCreateAccountsSQL(p)
+}
+
+func (p *Postgres) InsertAccountsSQL(first uint64, n uint64, balance int64) string {
+	return buildInsertAccountsSQL(p, first, n, balance)
+}
+
+func (p *Postgres) AddBalanceSQL() string {
+	return buildAddBalanceSQL(p)
+}
+
+func (p *Postgres) TotalBalanceSQL() string {
+	return buildTotalBalanceSQL(p)
+}
+
+func (p *Postgres) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (p *Postgres) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// BulkLoad loads entries by COPY FROM STDIN, rows are sent when the copy statement is executed without args.
+func (p *Postgres) BulkLoad(tx *sql.Tx, cfg *config.Config, entries []*archive.Entry) error {
+	stmt, err := tx.Prepare(pq.CopyIn(TestingTable, ColumnNames(cfg.ExtraColumnNum)...))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+	args := make([]interface{}, 0, cfg.ExtraColumnNum+2)
+	for _, entry := range entries {
+		args = append(args[:0], entry.Id, entry.Uuid)
+		for _, extra := range entry.ExtraUuid {
+			args = append(args, extra)
+		}
+		if _, err = stmt.Exec(args...); err != nil {
+			return err
+		}
+	}
+	// Driver doesn't return number of copied rows, an error of any row fails the copy.
+	_, err = stmt.Exec()
+	return err
+}
@@ -0,0 +1,169 @@
+package operator
+
+import (
+	"database/sql"
+	"donkey/pkg/archive"
+	"donkey/pkg/config"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteBusyTimeout is how long (ms) a routine waits for the lock of another routine.
+const sqliteBusyTimeout = 30000
+
+// SQLite is the operator of SQLite, the testing database is a local file.
+type SQLite struct{}
+
+func init() {
+	Register(&SQLite{})
+}
+
+func (s *SQLite) Name() string {
+	return "sqlite"
+}
+
+// Connect opens the database file of config. The file is the database,
+// so dbName is ignored.
+// All routines write the same file, WAL mode lets readers don't block writer,
+// and busy timeout makes writers wait for each other instead of failing with SQLITE_BUSY.
+func (s *SQLite) Connect(cfg *config.Config, _ string) (*sqlx.DB, error) {
+	// Path is escaped, otherwise '?', '#' or '%' in it is parsed as a part of URI.
+	path := (&url.URL{Path: cfg.Database}).EscapedPath()
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_txlock=immediate",
+		path, sqliteBusyTimeout)
+	return sqlx.Open("sqlite", dsn)
+}
+
+func (s *SQLite) CreateDatabase(_ *sqlx.DB, cfg *config.Config) error {
+	fmt.Printf("Database %s is a file of SQLite, don't need to create.\n", cfg.Database)
+	return nil
+}
+
+// DropDatabase removes the database file with its WAL and shared memory files.
+// Connections to the file must be closed before.
+func (s *SQLite) DropDatabase(_ *sqlx.DB, cfg *config.Config) error {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Remove(cfg.Database + suffix)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("SQLite remove database file failed, err:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLite) DropTableSQL(table string) string {
+	return buildDropTableSQL(s, table)
+}
+
+func (s *SQLite) CreateTable(db *sqlx.DB, cfg *config.Config) error {
+	existTable := false
+	err := db.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master " +
+		"WHERE type = 'table' AND name = 'donkey_test'").Scan(&existTable)
+	if err != nil {
+		fmt.Println("SQLite get tables failed, err:", err)
+		return err
+	}
+
+	if !existTable {
+		sql := `CREATE TABLE IF NOT EXISTS "donkey_test" (` +
+			`"id" INTEGER NOT NULL,` +
+			`"uuid" CHAR(36) NOT NULL,`
+		for i := uint(0); i < cfg.ExtraColumnNum; i++ {
+			sql += fmt.Sprintf(`"uuid_extra_%d" CHAR(36) NOT NULL,`, i)
+		}
+		sql += `PRIMARY KEY ("id")` +
+			`) ` + cfg.UniqueSyntax
+		_, err := db.Exec(sql)
+		if err != nil {
+			fmt.Println("SQLite create table failed, err:", err)
+			return err
+		}
+	} else {
+		// Check extra column num
+		columnCount := uint(0)
+		err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('donkey_test')").Scan(&columnCount)
+		if err != nil {
+			fmt.Println("SQLite check table column failed, err:", err)
+			return err
+		}
+		if columnCount != cfg.ExtraColumnNum+2 {
+			fmt.Printf("Extra column number is different. Testing table is [%d], config is [%d]\n",
+				columnCount-2, cfg.ExtraColumnNum)
+			return ErrDifferentColumnNum
+		}
+	}
+	return nil
+}
+
+func (s *SQLite) BatchInsertSQL(cfg *config.Config, entries []*archive.Entry) string {
+	return buildBatchInsertSQL(s, cfg, entries)
+}
+
+func (s *SQLite) PreparedInsertSQL(cfg *config.Config, n int) string {
+	return buildPreparedInsertSQL(s, cfg, n)
+}
+
+func (s *SQLite) PointLookupSQL() string {
+	return buildPointLookupSQL(s)
+}
+
+func (s *SQLite) BatchLookupSQL(n int) string {
+	return buildBatchLookupSQL(s, n)
+}
+
+func (s *SQLite) UpdateSQL(cfg *config.Config) string {
+	return buildUpdateSQL(s, cfg)
+}
+
+func (s *SQLite) DeleteSQL() string {
+	return buildDeleteSQL(s)
+}
+
+func (s *SQLite) MaxIdSQL() string {
+	return buildMaxIdSQL(s)
+}
+
+func (s *SQLite) IdScanSQL(limit int) string {
+	return buildIdScanSQL(s, limit)
+}
+
+func (s *SQLite) CreateAccountsSQL() string {
+	return buildCreateAccountsSQL(s)
+}
+
+func (s *SQLite) InsertAccountsSQL(first uint64, n uint64, balance int64) string {
+	return buildInsertAccountsSQL(s, first, n, balance)
+}
+
+func (s *SQLite) AddBalanceSQL() string {
+	return buildAddBalanceSQL(s)
+}
+
+func (s *SQLite) TotalBalanceSQL() string {
+	return buildTotalBalanceSQL(s)
+}
+
+// ConvertIsolation converts isolation level for driver, which only begins transaction of default level.
+// Transaction of SQLite is always serializable.
+func (s *SQLite) ConvertIsolation(level sql.IsolationLevel) (sql.IsolationLevel, error) {
+	if level == sql.LevelDefault || level == sql.LevelSerializable {
+		return sql.LevelDefault, nil
+	}
+	return level, ErrUnsupportedIsolation
+}
+
+func (s *SQLite) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (s *SQLite) Placeholder(_ int) string {
+	return "?"
+}
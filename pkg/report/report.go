@@ -0,0 +1,182 @@
+package report
+
+import (
+	"donkey/pkg/config"
+	"donkey/pkg/version"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxIds is the max number of failed ids of a class in report, the count is still exact.
+const MaxIds = 1000
+
+// Report is the result of a donkey run, for CI systems and scripts.
+type Report struct {
+	Version  Version        `json:"version"`
+	Config   *config.Config `json:"config"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Success  bool           `json:"success"`
+	Error    string         `json:"error,omitempty"`
+	Phases   []*Phase       `json:"phases"`
+	Failures []*Failure     `json:"failures"`
+	// Indeterminate is how indeterminate writes are classified by check.
+	Indeterminate Indeterminate `json:"indeterminate"`
+}
+
+// Version is build information of donkey.
+type Version struct {
+	Version    string `json:"version"`
+	GitHash    string `json:"git-hash"`
+	GitBranch  string `json:"git-branch"`
+	CommitDate string `json:"commit-date"`
+	BuildTime  string `json:"build-time"`
+	GoVersion  string `json:"go-version"`
+}
+
+// Phase is a phase of run, e.g. insert or check.
+type Phase struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	// Seconds is the duration of phase.
+	Seconds float64           `json:"seconds"`
+	Counts  map[string]uint64 `json:"counts,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// Failure is verification failures of a class, Ids has at most MaxIds ids.
+type Failure struct {
+	Class string   `json:"class"`
+	Count uint64   `json:"count"`
+	Ids   []uint64 `json:"ids,omitempty"`
+}
+
+// Indeterminate is the number of indeterminate writes in every state.
+type Indeterminate struct {
+	Committed uint64 `json:"committed"`
+	Absent    uint64 `json:"absent"`
+	Corrupted uint64 `json:"corrupted"`
+}
+
+// New returns report of config, password in config is masked.
+func New(cfg *config.Config) *Report {
+	c := *cfg
+	if c.Pass != "" {
+		c.Pass = "******"
+	}
+	return &Report{
+		Version: Version{
+			Version:    version.FullVersionInfo,
+			GitHash:    version.GitHash,
+			GitBranch:  version.GitBranch,
+			CommitDate: version.GitCommitDate,
+			BuildTime:  version.BuildTS,
+			GoVersion:  version.CompilerVersionInfo,
+		},
+		Config:   &c,
+		Phases:   []*Phase{},
+		Failures: []*Failure{},
+	}
+}
+
+// WriteJSON writes report to file as JSON.
+func (r *Report) WriteJSON(fileName string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0644)
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes report to file as JUnit XML. Every phase is a test case, it's an error if the phase failed.
+// Every class of verification failures is a failed test case.
+func (r *Report) WriteJUnit(fileName string) error {
+	suite := junitSuite{
+		Name:      "donkey",
+		Time:      seconds(r.End.Sub(r.Start).Seconds()),
+		Timestamp: r.Start.Format(time.RFC3339),
+	}
+	for _, phase := range r.Phases {
+		c := junitCase{Name: phase.Name, ClassName: "donkey.phase", Time: seconds(phase.Seconds)}
+		if len(phase.Counts) != 0 {
+			c.SystemOut = formatCounts(phase.Counts)
+		}
+		if phase.Error != "" {
+			c.Error = &junitMessage{Message: phase.Error, Type: "error"}
+			suite.Errors++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	for _, failure := range r.Failures {
+		ids := make([]string, 0, len(failure.Ids))
+		for _, id := range failure.Ids {
+			ids = append(ids, fmt.Sprint(id))
+		}
+		suite.Cases = append(suite.Cases, junitCase{
+			Name:      failure.Class,
+			ClassName: "donkey.verification",
+			Time:      seconds(0),
+			Failure: &junitMessage{
+				Message: fmt.Sprintf("%d %s failures", failure.Count, failure.Class),
+				Type:    failure.Class,
+				Text:    strings.Join(ids, " "),
+			},
+		})
+		suite.Failures++
+	}
+	suite.Tests = len(suite.Cases)
+	data, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+// formatCounts formats counts as sorted "key: value" lines.
+func formatCounts(counts map[string]uint64) string {
+	lines := make([]string, 0, len(counts))
+	for key, value := range counts {
+		lines = append(lines, fmt.Sprintf("%s: %d", key, value))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
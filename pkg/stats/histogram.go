@@ -0,0 +1,201 @@
+package stats
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// subBucketBits decides precision of histogram, values in a bucket differ less than 1/64.
+const subBucketBits = 7
+
+const (
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+	// bucketCount covers all positive int64 values.
+	bucketCount = subBucketCount + (64-subBucketBits)*subBucketHalf
+)
+
+// Histogram records durations in log-linear buckets like HDR histogram,
+// percentiles are accurate to about 1.5% without storing every value.
+// It's safe for concurrent use.
+type Histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make([]uint64, bucketCount)}
+}
+
+// bucketIndex returns index of bucket of value. Values less than sub bucket count have their own buckets,
+// bigger values share a bucket with values of the same highest bits.
+func bucketIndex(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	return subBucketCount + (shift-1)*subBucketHalf + int(v>>shift) - subBucketHalf
+}
+
+// bucketUpper returns the max value of bucket.
+func bucketUpper(index int) uint64 {
+	if index < subBucketCount {
+		return uint64(index)
+	}
+	shift := (index-subBucketCount)/subBucketHalf + 1
+	sub := uint64((index-subBucketCount)%subBucketHalf + subBucketHalf)
+	return (sub+1)<<shift - 1
+}
+
+// Record records a duration, negative duration is recorded as 0.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[bucketIndex(uint64(d))]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// Merge adds all values of other to h.
+func (h *Histogram) Merge(other *Histogram) {
+	other.mu.Lock()
+	c := other.copyLocked()
+	other.mu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, n := range c.counts {
+		h.counts[i] += n
+	}
+	if c.count != 0 && (h.count == 0 || c.min < h.min) {
+		h.min = c.min
+	}
+	if c.max > h.max {
+		h.max = c.max
+	}
+	h.count += c.count
+	h.sum += c.sum
+}
+
+// Snapshot returns a copy of h, and resets h if reset is true.
+func (h *Histogram) Snapshot(reset bool) *Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := h.copyLocked()
+	if reset {
+		h.counts = make([]uint64, bucketCount)
+		h.count, h.sum, h.min, h.max = 0, 0, 0, 0
+	}
+	return c
+}
+
+func (h *Histogram) copyLocked() *Histogram {
+	c := &Histogram{
+		counts: make([]uint64, bucketCount),
+		count:  h.count,
+		sum:    h.sum,
+		min:    h.min,
+		max:    h.max,
+	}
+	copy(c.counts, h.counts)
+	return c
+}
+
+// Count returns number of recorded values.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Max returns the max recorded value, 0 if there is none.
+func (h *Histogram) Max() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+// Mean returns the mean of recorded values, 0 if there is none.
+func (h *Histogram) Mean() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Percentile returns the value which q (0-100) percent of values are not bigger than.
+// It's the upper bound of bucket, but not bigger than max.
+func (h *Histogram) Percentile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(q / 100 * float64(h.count))
+	if float64(rank) < q/100*float64(h.count) {
+		rank++
+	}
+	if rank == 0 {
+		rank = 1
+	}
+	seen := uint64(0)
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			v := time.Duration(bucketUpper(i))
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// Sum returns sum of recorded values.
+func (h *Histogram) Sum() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+// CountBelow returns number of values in buckets whose upper bound is not bigger than each bound.
+// Bounds must be ascending.
+func (h *Histogram) CountBelow(bounds []time.Duration) []uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts := make([]uint64, len(bounds))
+	seen, b := uint64(0), 0
+	for i, n := range h.counts {
+		for b < len(bounds) && time.Duration(bucketUpper(i)) > bounds[b] {
+			counts[b] = seen
+			b++
+		}
+		if b == len(bounds) {
+			break
+		}
+		seen += n
+	}
+	for ; b < len(bounds); b++ {
+		counts[b] = seen
+	}
+	return counts
+}
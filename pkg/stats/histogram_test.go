@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogram_Percentile(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	for _, q := range []float64{50, 95, 99, 100} {
+		expect := float64(q/100*10000) * float64(time.Microsecond)
+		got := float64(h.Percentile(q))
+		if math.Abs(got-expect)/expect > 0.016 {
+			t.Errorf("p%v is %v, expect about %v", q, time.Duration(got), time.Duration(expect))
+		}
+	}
+	if h.Max() != 10*time.Millisecond || h.Count() != 10000 {
+		t.Errorf("Max is %v and count is %d, expect 10ms and 10000", h.Max(), h.Count())
+	}
+	if h.Mean() != 5000500*time.Nanosecond {
+		t.Errorf("Mean is %v, expect 5.0005ms", h.Mean())
+	}
+}
+
+func TestHistogram_Bucket(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 129, 1000, 1 << 40, math.MaxInt64} {
+		i := bucketIndex(v)
+		if i >= bucketCount || bucketUpper(i) < v || (i > 0 && bucketUpper(i-1) >= v) {
+			t.Errorf("Value %d is in bucket %d of upper %d", v, i, bucketUpper(i))
+		}
+	}
+}
+
+func TestHistogram_SnapshotMerge(t *testing.T) {
+	h := NewHistogram()
+	h.Record(time.Millisecond)
+	h.Record(3 * time.Millisecond)
+	s := h.Snapshot(true)
+	if h.Count() != 0 || s.Count() != 2 {
+		t.Fatalf("Snapshot has %d values and histogram has %d, expect 2 and 0", s.Count(), h.Count())
+	}
+	h.Record(5 * time.Millisecond)
+	h.Merge(s)
+	if h.Count() != 3 || h.Max() != 5*time.Millisecond || h.Percentile(1) > 1016*time.Microsecond {
+		t.Errorf("Merged histogram has %d values, max %v, min %v", h.Count(), h.Max(), h.Percentile(1))
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	r.Record("insert", time.Millisecond, true)
+	r.Record("insert", time.Second, false)
+	r.Record("lookup", time.Microsecond, true)
+	interval := r.TakeInterval()
+	if len(interval) != 2 || interval[0].Op != "insert" || interval[0].Err.Count() != 1 {
+		t.Fatalf("Interval stats are wrong: %+v", interval)
+	}
+	r.Record("lookup", time.Microsecond, true)
+	interval = r.TakeInterval()
+	if len(interval) != 1 || interval[0].Op != "lookup" {
+		t.Errorf("New interval should only have lookup: %+v", interval)
+	}
+	total := r.Total()
+	if len(total) != 2 || total[0].Ok.Count() != 1 || total[1].Ok.Count() != 2 {
+		t.Errorf("Total stats are wrong: %+v", total)
+	}
+	r.Reset()
+	if len(r.Total()) != 0 {
+		t.Error("Reset recorder should be empty")
+	}
+}
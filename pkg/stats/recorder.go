@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OpStat is latency of an operation, split by success and error.
+type OpStat struct {
+	Op  string
+	Ok  *Histogram
+	Err *Histogram
+}
+
+func newOpStat(op string) *OpStat {
+	return &OpStat{Op: op, Ok: NewHistogram(), Err: NewHistogram()}
+}
+
+// Recorder records latency of operations of a phase, for both the whole phase and the current interval.
+type Recorder struct {
+	mu       sync.Mutex
+	ops      []string
+	total    map[string]*OpStat
+	interval map[string]*OpStat
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		total:    make(map[string]*OpStat),
+		interval: make(map[string]*OpStat),
+	}
+}
+
+// Record records latency of a successful or failed operation.
+func (r *Recorder) Record(op string, d time.Duration, ok bool) {
+	r.mu.Lock()
+	total, found := r.total[op]
+	if !found {
+		r.ops = append(r.ops, op)
+		total = newOpStat(op)
+		r.total[op] = total
+	}
+	interval, found := r.interval[op]
+	if !found {
+		interval = newOpStat(op)
+		r.interval[op] = interval
+	}
+	r.mu.Unlock()
+	if ok {
+		total.Ok.Record(d)
+		interval.Ok.Record(d)
+	} else {
+		total.Err.Record(d)
+		interval.Err.Record(d)
+	}
+}
+
+// Since records latency of an operation began at start.
+func (r *Recorder) Since(op string, start time.Time, ok bool) {
+	r.Record(op, time.Since(start), ok)
+}
+
+// Total returns latency of operations since the recorder is created or reset, in order of first record.
+func (r *Recorder) Total() []*OpStat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make([]*OpStat, 0, len(r.ops))
+	for _, op := range r.ops {
+		s := r.total[op]
+		stats = append(stats, &OpStat{Op: op, Ok: s.Ok.Snapshot(false), Err: s.Err.Snapshot(false)})
+	}
+	return stats
+}
+
+// TakeInterval returns latency of operations since the last interval, and begins a new interval.
+func (r *Recorder) TakeInterval() []*OpStat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make([]*OpStat, 0, len(r.ops))
+	for _, op := range r.ops {
+		if s, ok := r.interval[op]; ok {
+			stats = append(stats, s)
+		}
+	}
+	r.interval = make(map[string]*OpStat)
+	return stats
+}
+
+// Reset drops all recorded latency.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = nil
+	r.total = make(map[string]*OpStat)
+	r.interval = make(map[string]*OpStat)
+}
+
+// Format returns a line of throughput and latency of histogram in elapsed time.
+func Format(h *Histogram, elapsed time.Duration) string {
+	count := h.Count()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(count) / elapsed.Seconds()
+	}
+	return fmt.Sprintf("%d ops (%.1f/s) p50 %s p95 %s p99 %s max %s", count, rate,
+		round(h.Percentile(50)), round(h.Percentile(95)), round(h.Percentile(99)), round(h.Max()))
+}
+
+// round drops digits which don't matter in printing.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= 100*time.Millisecond:
+		return d.Round(time.Millisecond)
+	case d >= 100*time.Microsecond:
+		return d.Round(time.Microsecond)
+	default:
+		return d
+	}
+}